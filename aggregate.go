@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// Aggregate holds the entries collected from the roots of a run by a generator created with NewAggregatingGenerator,
+// to render its aggregate file.
+type Aggregate struct {
+	// Imports are the import paths of the packages with entries, by the name the entries refer to them with.
+	Imports map[string]string
+	// Entries are the entries of all the packages, in the order of the roots.
+	Entries []string
+}
+
+// ImportDecl returns the import declaration of the packages of the aggregate, sorted by import path, or an empty string
+// if there is none.
+func (a Aggregate) ImportDecl() string {
+	if len(a.Imports) == 0 {
+		return ""
+	}
+
+	aliases := SortedKeys(a.Imports)
+	sort.SliceStable(aliases, func(i, j int) bool { return a.Imports[aliases[i]] < a.Imports[aliases[j]] })
+
+	buf := new(strings.Builder)
+	buf.WriteString("import (\n")
+
+	for _, alias := range aliases {
+		fmt.Fprintf(buf, "\t%s %q\n", alias, a.Imports[alias])
+	}
+
+	buf.WriteString(")\n")
+
+	return buf.String()
+}
+
+// NewAggregatingGenerator returns a generator writing a single file which aggregates entries across all the roots of a
+// run, e.g. a scheme builder or a plugin registry. Like NewSimpleGenerator, it registers the given marker, if not nil.
+//
+// Once all the generators ran, entries is invoked for each root with the name the aggregate file imports the root with,
+// unique across the roots, and returns the entries of the root, if any, e.g. "v1.AddToScheme". The errors are recorded
+// on the root and fail the run. Then render is invoked with the entries of all the roots, and the imports of the roots
+// with entries, to write the aggregate file:
+//
+//	genutils.NewAggregatingGenerator(marker,
+//		func(ctx *genall.GenerationContext, root *loader.Package, alias string) ([]string, error) {
+//			// look up the types annotated with the marker in the root
+//			return []string{alias + ".AddToScheme"}, nil
+//		},
+//		func(ctx *genall.GenerationContext, aggregate genutils.Aggregate) error {
+//			// render aggregate.ImportDecl() and aggregate.Entries, and write the file with ctx.Open
+//			return nil
+//		})
+//
+// The aggregate only covers the roots of the run: with --incremental, the packages which are up to date are missing.
+func NewAggregatingGenerator(
+	marker *markers.Definition,
+	entries func(ctx *genall.GenerationContext, root *loader.Package, alias string) ([]string, error),
+	render func(ctx *genall.GenerationContext, aggregate Aggregate) error,
+) genall.Generator {
+	// the aggregate is collected when finalizing each run, so no state is shared between runs or commands
+	return simpleGenerator{
+		marker: marker,
+		fn:     func(*genall.GenerationContext) error { return nil },
+		finalize: func(ctx *genall.GenerationContext) error {
+			aggregate, err := collectAggregate(ctx, entries)
+
+			return errors.Join(err, render(ctx, aggregate))
+		},
+	}
+}
+
+// collectAggregate invokes entries for each root of the run, recording the errors on the roots. It returns the errors
+// joined, along with the aggregate of the roots without errors.
+func collectAggregate(
+	ctx *genall.GenerationContext,
+	entries func(ctx *genall.GenerationContext, root *loader.Package, alias string) ([]string, error),
+) (Aggregate, error) {
+	aggregate := Aggregate{Imports: make(map[string]string), Entries: make([]string, 0)}
+	taken := make(map[string]bool)
+	errs := make([]error, 0)
+
+	for _, root := range ctx.Roots {
+		alias := root.Name
+		for n := 2; taken[alias]; n++ {
+			alias = fmt.Sprintf("%s_%d", root.Name, n)
+		}
+
+		rootEntries, err := entries(ctx, root, alias)
+		if err != nil {
+			root.AddError(err)
+			errs = append(errs, fmt.Errorf("%s: %w", root.PkgPath, err))
+		}
+
+		if len(rootEntries) == 0 {
+			continue
+		}
+
+		taken[alias] = true
+		aggregate.Imports[alias] = root.PkgPath
+		aggregate.Entries = append(aggregate.Entries, rootEntries...)
+	}
+
+	return aggregate, errors.Join(errs...)
+}
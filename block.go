@@ -0,0 +1,73 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+)
+
+// UpdateBlock replaces the content of the block delimited by the lines startMarker and endMarker of the existing file
+// at path with content, e.g. to generate a table of constants inside an otherwise hand-written file. The markers are
+// compared with each line stripped of surrounding whitespace, e.g. "// +yourgen:table:start", and are kept in the file.
+// Go files are formatted as a whole after the update, see FormatGoFiles. It fails if the file has no block, more than
+// one, or if the markers are nested or unbalanced, without modifying the file.
+func UpdateBlock(path, startMarker, endMarker string, content []byte) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	regions, err := markedRegions(src, startMarker, endMarker)
+	if err != nil {
+		return fmt.Errorf("cannot update the block of %q: %w", path, err)
+	}
+
+	if len(regions) != 1 {
+		return fmt.Errorf("cannot update the block of %q: expected a single block delimited by %q and %q, found %d",
+			path, startMarker, endMarker, len(regions))
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(src[:regions[0][0]])
+	out.Write(content)
+	out.Write(src[regions[0][1]:])
+
+	outBytes := out.Bytes()
+
+	if FormatGoFiles(path) {
+		formatted, err := format.Source(outBytes)
+		if err != nil {
+			return fmt.Errorf("cannot format %q after updating its block: %w", path, err)
+		}
+
+		outBytes = formatted
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return os.WriteFile(path, outBytes, info.Mode().Perm()) //nolint:wrapcheck
+}
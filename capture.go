@@ -0,0 +1,65 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// capture holds the files generated by a run and the errors recorded on its packages, see GenerateToMap.
+type capture struct {
+	files map[string][]byte
+	errs  []error
+}
+
+// GenerateToMap runs the generators activated by the given options like Generate, but returns the generated files by
+// the path they would have been written to instead of writing them, like --verify does, e.g. to bundle them into an
+// embed.FS or to compare them in tests. The files of the output rules not writing to the filesystem (e.g. "stdout") are
+// discarded.
+//
+// If not all generators ran successfully, the files generated anyway are returned along with an error joining
+// ErrGeneration and the errors recorded on the packages.
+func (c Cmd) GenerateToMap(opts []string) (map[string][]byte, error) {
+	c.capture = &capture{files: make(map[string][]byte)}
+
+	err := c.Generate(opts)
+	if errors.Is(err, ErrGeneration) {
+		err = errors.Join(append([]error{err}, c.capture.errs...)...)
+	}
+
+	return c.capture.files, err
+}
+
+// collect records the buffered files, and the errors recorded on the roots but the type errors, like the errors
+// printed after a run.
+func (c *capture) collect(files map[string]*bytes.Buffer, roots []*loader.Package) {
+	for path, buffer := range files {
+		c.files[path] = buffer.Bytes()
+	}
+
+	for _, root := range roots {
+		for _, err := range root.Errors {
+			if err.Kind != packages.TypeError {
+				c.errs = append(c.errs, err)
+			}
+		}
+	}
+}
@@ -23,10 +23,17 @@ import (
 	"github.com/alexandremahdhaoui/genutils"
 	"github.com/dave/jennifer/jen"
 	"github.com/spf13/cobra"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
-	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sort"
 	"strings"
+	"unicode"
 )
 
 const (
@@ -78,13 +85,97 @@ not setup the generator in your
 cmd.
 
 	genutils --generators "<GENERATOR_NAME>:<PATH>,<ANOTHER_GEN_NAME>:<MAYBE_ANOTHER_PATH>"
+`
+
+	initOutputRulesFlag      = "output-rules"
+	initOutputRulesFlagShort = "o"
+	initOutputRulesUsage     = `The command below will initialize
+a new output rule under
+"./<PATH>/<OUTPUT_RULE_NAME>_output.go".
+When used with "--cmd", the output
+rule is wired in the cmd.
+
+	genutils --output-rules "<OUTPUT_RULE_NAME>:<PATH>"
+`
+
+	appendToCmdFlag  = "append-to-cmd"
+	appendToCmdUsage = `Wire the generators and output rules
+in the existing cmd under
+"./cmd/<CMD_NAME>/main.go" (or
+"command.go" with "--scaffold-as
+subcommand") instead of initializing a
+new cmd.
+
+	genutils --cmd mycmd --append-to-cmd --generators=newGenerator:./some/pkg
+`
+
+	scaffoldFromFlag  = "scaffold-from"
+	scaffoldFromUsage = `Walk the directory and initialize a
+generator in each package marked with
+"// +genutils:scaffold", named after the
+directory of the package, or with
+"// +genutils:scaffold=<GENERATOR_NAME>".
+When used with "--cmd", the generators
+are wired in the cmd.
+
+	genutils --cmd mycmd --scaffold-from ./pkg
+`
+
+	scaffoldAsFlag  = "scaffold-as"
+	scaffoldAsUsage = `Initialize the cmd as "main", i.e. a
+standalone "./cmd/<CMD_NAME>/main.go", or
+as "subcommand", i.e. a package exporting
+"func NewCommand() *cobra.Command" under
+"./cmd/<CMD_NAME>/command.go", to be added
+to a parent cobra command.
+
+	genutils --cmd mycmd --scaffold-as subcommand
+`
+	scaffoldAsMain       = "main"
+	scaffoldAsSubcommand = "subcommand"
+
+	buildFlagsFlag  = "build-flags"
+	buildFlagsUsage = `Pass the flag to the build system when
+loading the packages of the generators
+and output rules, e.g. "-tags=linux".
+Can be repeated.
+`
+
+	withDocFlag  = "with-doc"
+	withDocUsage = `Also initialize a "doc.go" for each
+generator under "./<PATH>/doc.go", with a
+package comment documenting its marker.
+Skipped if the file already exists.
+`
+
+	withWrapperFlag  = "with-wrapper"
+	withWrapperUsage = `Also initialize a "generate.sh" script
+running the generators of the cmd with
+"paths=./..." and, if it exists, the
+"hack/boilerplate.go.txt" header file.
+Requires "--cmd". Skipped if the file
+already exists.
+`
+
+	withTestsFlag  = "with-tests"
+	withTestsUsage = `Also initialize a smoke test for
+each output rule under
+"./<PATH>/<OUTPUT_RULE_NAME>_output_test.go".
 `
 )
 
 var (
-	version        = "<unversioned>"
-	initCmd        *string
-	initGenerators *string
+	version         = "<unversioned>"
+	initCmd         *string
+	initGenerators  *string
+	initOutputRules *string
+	withTests       *bool
+	withDoc         *bool
+	withWrapper     *bool
+	appendToCmd     *bool
+	scaffoldFrom    *string
+	scaffoldAs      *string
+	buildFlags      *[]string
 )
 
 func main() {
@@ -99,9 +190,25 @@ func main() {
 
 	initCmd = new(string)
 	initGenerators = new(string)
+	initOutputRules = new(string)
+	withTests = new(bool)
+	withDoc = new(bool)
+	withWrapper = new(bool)
+	appendToCmd = new(bool)
+	scaffoldFrom = new(string)
+	scaffoldAs = new(string)
+	buildFlags = new([]string)
 
 	command.Flags().StringVarP(initCmd, initCmdFlag, initCmdFlagShort, "", initCmdUsage)
 	command.Flags().StringVarP(initGenerators, initGeneratorsFlag, initGeneratorsFlagShort, "", initGeneratorsUsage)
+	command.Flags().StringVarP(initOutputRules, initOutputRulesFlag, initOutputRulesFlagShort, "", initOutputRulesUsage)
+	command.Flags().BoolVar(withTests, withTestsFlag, false, withTestsUsage)
+	command.Flags().BoolVar(withDoc, withDocFlag, false, withDocUsage)
+	command.Flags().BoolVar(withWrapper, withWrapperFlag, false, withWrapperUsage)
+	command.Flags().BoolVar(appendToCmd, appendToCmdFlag, false, appendToCmdUsage)
+	command.Flags().StringVar(scaffoldFrom, scaffoldFromFlag, "", scaffoldFromUsage)
+	command.Flags().StringVar(scaffoldAs, scaffoldAsFlag, scaffoldAsMain, scaffoldAsUsage)
+	command.Flags().StringArrayVar(buildFlags, buildFlagsFlag, nil, buildFlagsUsage)
 
 	if err := command.Execute(); err != nil {
 		fmt.Printf("error while running %s:\n%s", name, err.Error()) //nolint:forbidigo
@@ -111,7 +218,7 @@ func main() {
 
 // RUN COMMAND ---------------------------------------------------------------------------------------------------------
 
-func runE(_ *cobra.Command, _ []string) error {
+func runE(_ *cobra.Command, _ []string) error { //nolint:cyclop
 	cmd, err := parseCmdAndValidate(*initCmd)
 	if err != nil {
 		return err
@@ -122,22 +229,84 @@ func runE(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	switch {
-	case cmd != nil && len(generators) == 0:
-		return generateCmd(*cmd)
-	case cmd != nil:
-		if err = generateGeneratorWithCmdName(generators, cmd.name); err != nil {
+	outputRules, err := parseOutputRulesAndValidate(*initOutputRules)
+	if err != nil {
+		return err
+	}
+
+	if *appendToCmd && cmd == nil {
+		return fmt.Errorf("\"--%s\" requires \"--%s\"", appendToCmdFlag, initCmdFlag)
+	}
+
+	if *withWrapper && cmd == nil {
+		return fmt.Errorf("\"--%s\" requires \"--%s\"", withWrapperFlag, initCmdFlag)
+	}
+
+	if err := validateScaffoldAs(*scaffoldAs); err != nil {
+		return err
+	}
+
+	if cmd != nil {
+		cmd.subcommand = *scaffoldAs == scaffoldAsSubcommand
+	}
+
+	if *withWrapper && cmd.subcommand {
+		return fmt.Errorf("\"--%s\" requires a standalone cmd, i.e. \"--%s %s\"", withWrapperFlag, scaffoldAsFlag,
+			scaffoldAsMain)
+	}
+
+	if cmd == nil && len(generators) == 0 && len(outputRules) == 0 && *scaffoldFrom == "" {
+		return fmt.Errorf("expected at least one of \"--%s\", \"--%s\", \"--%s\" or \"--%s\"",
+			initCmdFlag, initGeneratorsFlag, initOutputRulesFlag, scaffoldFromFlag)
+	}
+
+	var scaffolded []generatorFlag
+
+	var skipped []skippedPackage
+
+	if *scaffoldFrom != "" {
+		if scaffolded, skipped, err = findScaffoldCandidates(*scaffoldFrom, generators); err != nil {
 			return err
 		}
 
-		if err = generateCmdWithGenerators(*cmd, generators); err != nil {
+		generators = append(generators, scaffolded...)
+	}
+
+	cmdName := ""
+	if cmd != nil {
+		cmdName = cmd.name
+	}
+
+	if err = generateGeneratorWithCmdName(generators, cmdName, *withDoc); err != nil {
+		return err
+	}
+
+	if err = generateOutputRules(outputRules, *withTests); err != nil {
+		return err
+	}
+
+	switch {
+	case cmd != nil && *appendToCmd:
+		err = appendToExistingCmd(*cmd, generators, outputRules)
+	case cmd != nil:
+		err = generateCmdWithGenerators(*cmd, generators, outputRules)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if *withWrapper {
+		if err = generateWrapper(*cmd, generators); err != nil {
 			return err
 		}
+	}
 
-		return nil
-	default: // cmd == nil && len(generators) > 0
-		return generateGenerator(generators)
+	if *scaffoldFrom != "" {
+		printScaffoldSummary(scaffolded, skipped)
 	}
+
+	return nil
 }
 
 // PARSE FLAGS AND VALIDATE --------------------------------------------------------------------------------------------
@@ -145,10 +314,18 @@ func runE(_ *cobra.Command, _ []string) error {
 type (
 	cmdFlag struct {
 		name, path string
+		// subcommand initializes the cmd as a package exporting NewCommand instead of a main package.
+		subcommand bool
 	}
 
 	generatorFlag struct {
 		name, path string
+		// pkgName is the name of the existing package the generator is initialized in, if any.
+		pkgName string
+	}
+
+	outputRuleFlag struct {
+		name, path string
 	}
 )
 
@@ -157,6 +334,10 @@ func parseCmdAndValidate(s string) (*cmdFlag, error) {
 		return nil, nil
 	}
 
+	if err := genutils.ValidateMarkerName(s); err != nil {
+		return nil, errors.Join(err, newInvalidFlagInputErr(initCmdFlag))
+	}
+
 	return &cmdFlag{
 		name: s,
 		path: fmt.Sprintf("cmd/%s", s),
@@ -164,6 +345,10 @@ func parseCmdAndValidate(s string) (*cmdFlag, error) {
 }
 
 func parseGeneratorsAndValidate(s string) ([]generatorFlag, error) {
+	if s == "" {
+		return nil, nil
+	}
+
 	parseGeneratorsErr := errors.Join(
 		fmt.Errorf("received: %q", s),
 		newInvalidFlagInputErr(initGeneratorsFlag),
@@ -184,6 +369,10 @@ func parseGeneratorsAndValidate(s string) ([]generatorFlag, error) {
 			return nil, errors.Join(errors.New("name cannot be empty"), parseGeneratorsErr)
 		}
 
+		if err := genutils.ValidateMarkerName(genName); err != nil {
+			return nil, errors.Join(err, parseGeneratorsErr)
+		}
+
 		genPath := sl[1]
 		if genPath == "" {
 			return nil, errors.Join(errors.New("path cannot be empty"), parseGeneratorsErr)
@@ -206,6 +395,62 @@ func parseGeneratorsAndValidate(s string) ([]generatorFlag, error) {
 	return generators, nil
 }
 
+func parseOutputRulesAndValidate(s string) ([]outputRuleFlag, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parseOutputRulesErr := errors.Join(
+		fmt.Errorf("received: %q", s),
+		newInvalidFlagInputErr(initOutputRulesFlag),
+		fmt.Errorf("usage: %s", initOutputRulesUsage),
+	)
+
+	outputRules := make([]outputRuleFlag, 0)
+
+	for _, input := range strings.Split(s, ",") {
+		sl := strings.Split(input, ":")
+		if len(sl) != 2 {
+			return nil, errors.Join(
+				errors.New("expect 2 sub-arguments separated by a colon (\":\")"), parseOutputRulesErr)
+		}
+
+		ruleName, rulePath := sl[0], sl[1]
+		if ruleName == "" {
+			return nil, errors.Join(errors.New("name cannot be empty"), parseOutputRulesErr)
+		}
+
+		if err := genutils.ValidateMarkerName(ruleName); err != nil {
+			return nil, errors.Join(err, parseOutputRulesErr)
+		}
+
+		if rulePath == "" {
+			return nil, errors.Join(errors.New("path cannot be empty"), parseOutputRulesErr)
+		}
+
+		if err := fileShouldNotExist(filepath.Join(rulePath, outputRuleFilename(ruleName))); err != nil {
+			return nil, errors.Join(err, parseOutputRulesErr)
+		}
+
+		outputRules = append(outputRules, outputRuleFlag{
+			name: ruleName,
+			path: rulePath,
+		})
+	}
+
+	return outputRules, nil
+}
+
+// validateScaffoldAs returns an error if the value of "--scaffold-as" is neither "main" nor "subcommand".
+func validateScaffoldAs(s string) error {
+	if s != scaffoldAsMain && s != scaffoldAsSubcommand {
+		return errors.Join(fmt.Errorf("expected %q or %q, got %q", scaffoldAsMain, scaffoldAsSubcommand, s),
+			newInvalidFlagInputErr(scaffoldAsFlag))
+	}
+
+	return nil
+}
+
 func newInvalidFlagInputErr(flagName string) error {
 	return fmt.Errorf("invalid input for flag \"--%s\"", flagName)
 }
@@ -222,11 +467,8 @@ func fileShouldNotExist(path string) error {
 
 // GENERATE COMMAND --------------------------------------------------------------------------------------------------
 
-func generateCmd(cmd cmdFlag) error {
-	return generateCmdWithGenerators(cmd, nil)
-}
-
-func generateCmdWithGenerators(cmd cmdFlag, generators []generatorFlag) error {
+//nolint:funlen
+func generateCmdWithGenerators(cmd cmdFlag, generators []generatorFlag, outputRules []outputRuleFlag) error {
 	genutilsImport := "github.com/alexandremahdhaoui/genutils"
 
 	// genutils.New(name).
@@ -248,20 +490,32 @@ func generateCmdWithGenerators(cmd cmdFlag, generators []generatorFlag) error {
 		//	)
 		consts = append(consts, jen.Id(genName).Op("=").Lit(g.name))
 
-		roots, err := loader.LoadRoots(g.path)
+		pkgPath, err := genutils.ResolveImportPath(g.path, *buildFlags...)
 		if err != nil {
 			return err
 		}
 
-		if len(roots) == 0 {
-			return fmt.Errorf("expected at least on package located in %q", g.path)
-		}
-
 		//		WithGenerator(cmdGeneratorName, cmd.CmdGenerator{}).
 		//		WithGenerator(generatorGeneratorName, cmd.GeneratorGenerator{}).
 		genutilsNew = genutilsNew.
 			Dot("WithGenerator").
-			Call(jen.Id(genName), jen.Qual(roots[0].String(), genStruct).Values())
+			Call(jen.Id(genName), jen.Qual(pkgPath, genStruct).Values())
+	}
+
+	for _, o := range outputRules {
+		ruleName := fmt.Sprintf("%sOutputRuleName", o.name)
+
+		consts = append(consts, jen.Id(ruleName).Op("=").Lit(o.name))
+
+		pkgPath, err := genutils.ResolveImportPath(o.path, *buildFlags...)
+		if err != nil {
+			return err
+		}
+
+		//		WithOutputRule(myRuleOutputRuleName, pkg.MyRuleOutputRule("")).
+		genutilsNew = genutilsNew.
+			Dot("WithOutputRule").
+			Call(jen.Id(ruleName), jen.Qual(pkgPath, outputRuleTypeName(o.name)).Call(jen.Lit("")))
 	}
 
 	consts = append([]jen.Code{
@@ -276,6 +530,10 @@ func generateCmdWithGenerators(cmd cmdFlag, generators []generatorFlag) error {
 	//		example     = ``
 	constBlock := jen.Const().Defs(consts...)
 
+	if cmd.subcommand {
+		return generateSubcommand(cmd, constBlock, genutilsNew)
+	}
+
 	//		Apply().
 	//		Run()
 	genutilsNew.
@@ -288,7 +546,52 @@ func generateCmdWithGenerators(cmd cmdFlag, generators []generatorFlag) error {
 		genutilsNew,
 	)
 
-	return writeFile(f, cmd.path, "main.go")
+	return writeFile(f, cmd.path, cmdFilename(cmd))
+}
+
+// generateSubcommand writes the cmd as a package exporting NewCommand, which returns the command built by the builder
+// chain, to be added to a parent cobra command.
+func generateSubcommand(cmd cmdFlag, constBlock, genutilsNew *jen.Statement) error {
+	cobraImport := "github.com/spf13/cobra"
+
+	f := jen.NewFilePath(cmd.path) //nolint:varnamelen
+
+	// func NewCommand() *cobra.Command {
+	//		parent := &cobra.Command{}
+	//		genutils.New(name).
+	//			...
+	//			Apply().
+	//			AddTo(parent, "")
+	//
+	//		cmd := parent.Commands()[0]
+	//		parent.RemoveCommand(cmd)
+	//
+	//		return cmd
+	// }
+	f.Add(constBlock)
+	f.Comment("NewCommand returns the command, to be added to a parent command.")
+	f.Func().Id("NewCommand").Params().Op("*").Qual(cobraImport, "Command").Block(
+		jen.Id("parent").Op(":=").Op("&").Qual(cobraImport, "Command").Values(),
+		genutilsNew.Dot("Apply").Call().Dot("AddTo").Call(jen.Id("parent"), jen.Lit("")),
+		jen.Line(),
+		jen.Comment("detach the command from the parent it was built with, so it can be added to another one"),
+		jen.Id("cmd").Op(":=").Id("parent").Dot("Commands").Call().Index(jen.Lit(0)),
+		jen.Id("parent").Dot("RemoveCommand").Call(jen.Id("cmd")),
+		jen.Line(),
+		jen.Return(jen.Id("cmd")),
+	)
+
+	return writeFile(f, cmd.path, cmdFilename(cmd))
+}
+
+// cmdFilename returns the name of the file of the cmd, "main.go" for a standalone cmd, or "command.go" for a
+// subcommand.
+func cmdFilename(cmd cmdFlag) string {
+	if cmd.subcommand {
+		return "command.go"
+	}
+
+	return "main.go"
 }
 
 func writeFile(f *jen.File, pathToJoin ...string) error {
@@ -298,27 +601,28 @@ func writeFile(f *jen.File, pathToJoin ...string) error {
 	}
 
 	fp := filepath.Join(pathToJoin...)
-	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil { //nolint:gofumpt
-		return err
-	}
 
-	return os.WriteFile(fp, buf.Bytes(), 0644) //nolint:gosec,gofumpt
+	// the package clause is rendered by jen
+	return genutils.WriteFile(genutils.WriteFileOption{
+		Filename:  filepath.Base(fp),
+		OutputDir: filepath.Dir(fp),
+		Buffer:    buf,
+	})
 }
 
 // GENERATE GENERATOR --------------------------------------------------------------------------------------------------
 
-func generateGenerator(generators []generatorFlag) error {
-	return generateGeneratorWithCmdName(generators, "")
-}
-
 //nolint:funlen
-func generateGeneratorWithCmdName(generators []generatorFlag, cmdName string) error {
+func generateGeneratorWithCmdName(generators []generatorFlag, cmdName string, withDoc bool) error {
 	for _, g := range generators {
 		f := jen.NewFilePath(g.path) //nolint:varnamelen
+		if g.pkgName != "" {
+			f = jen.NewFilePathName(g.path, g.pkgName)
+		}
 
-		marker := g.name
+		marker := genutils.MarkerName(g.name)
 		if cmdName != "" {
-			marker = fmt.Sprintf("%s:%s", cmdName, marker)
+			marker = genutils.MarkerName(cmdName, g.name)
 		}
 
 		markerLit := jen.Lit(marker)
@@ -327,7 +631,8 @@ func generateGeneratorWithCmdName(generators []generatorFlag, cmdName string) er
 		markersPath := "sigs.k8s.io/controller-tools/pkg/markers"
 		genallPath := "sigs.k8s.io/controller-tools/pkg/genall"
 
-		omitemptyMarkerTag := map[string]string{"marker": ",omitempty"}
+		// the options are optional, so the generator can be enabled by its name alone
+		optionalMarkerTag := map[string]string{"marker": ",optional"}
 
 		markerDefName := fmt.Sprintf("%sMarkerDefinition", g.name)
 
@@ -350,8 +655,8 @@ func generateGeneratorWithCmdName(generators []generatorFlag, cmdName string) er
 		f.Type().
 			Id(generatorNameTitle).
 			Struct(
-				jen.Id("HeaderFile").String().Tag(omitemptyMarkerTag),
-				jen.Id("Year").String().Tag(omitemptyMarkerTag),
+				jen.Id("HeaderFile").String().Tag(optionalMarkerTag),
+				jen.Id("Year").String().Tag(optionalMarkerTag),
 			)
 
 		// func (ContainerGenerator) RegisterMarkers(into *markers.Registry) error {
@@ -436,11 +741,633 @@ func generateGeneratorWithCmdName(generators []generatorFlag, cmdName string) er
 				jen.Return(jen.Nil()),
 			)
 
-		filename := fmt.Sprintf("%s.go", strings.ToLower(g.name))
-		if err := writeFile(f, g.path, filename); err != nil {
+		if err := writeFile(f, g.path, generatorFilename(g.name)); err != nil {
+			return err
+		}
+
+		if !withDoc {
+			continue
+		}
+
+		if err := generateGeneratorDoc(g, marker, cmdName); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// generateGeneratorDoc initializes the "doc.go" of the package of the generator, with a package comment documenting
+// its marker, unless the file already exists.
+func generateGeneratorDoc(g generatorFlag, marker, cmdName string) error {
+	docPath := filepath.Join(g.path, "doc.go")
+	if err := fileShouldNotExist(docPath); err != nil {
+		if _, statErr := os.Stat(docPath); statErr != nil {
+			return err
+		}
+
+		fmt.Printf("skipped %s: file already exists\n", docPath) //nolint:forbidigo
+
+		return nil
+	}
+
+	f := jen.NewFilePath(g.path) //nolint:varnamelen
+	if g.pkgName != "" {
+		f = jen.NewFilePathName(g.path, g.pkgName)
+	}
+
+	pkgName, err := renderedPackageName(f)
+	if err != nil {
+		return err
+	}
+
+	usage := fmt.Sprintf("%s %s paths=./...", cmdName, g.name)
+	if cmdName == "" {
+		usage = fmt.Sprintf("<CMD_NAME> %s paths=./...", g.name)
+	}
+
+	// // Package mypkg implements the MyGenerator generator.
+	// //
+	// // The generator runs for the types marked with the "+mycmd:my" marker:
+	// // ...
+	// package mypkg
+	lines := []string{
+		fmt.Sprintf("Package %s implements the %sGenerator generator.", pkgName, genutils.Title(g.name)),
+		"",
+		fmt.Sprintf("The generator runs for the types marked with the %q marker:", "+"+marker),
+		"",
+		fmt.Sprintf("\t// +%s", marker),
+		"\ttype MyType struct{}",
+		"",
+		"Its options can be set on the marker, e.g. the boilerplate header of the generated files:",
+		"",
+		fmt.Sprintf("\t// +%s:headerFile=\"hack/boilerplate.go.txt\",year=\"2023\"", marker),
+		"",
+		"The generator is enabled from the command line by its name:",
+		"",
+		"\t" + usage,
+	}
+
+	// jen renders multiline comments as a block comment, unless they are already prefixed
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("// "+line, " ")
+	}
+
+	f.PackageComment(strings.Join(lines, "\n"))
+
+	return writeFile(f, g.path, "doc.go")
+}
+
+// renderedPackageName returns the name of the package clause jen renders for the file.
+func renderedPackageName(f *jen.File) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := f.Render(buf); err != nil {
+		return "", err
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+
+	return file.Name.Name, nil
+}
+
+func generatorFilename(name string) string {
+	return fmt.Sprintf("%s.go", strings.ToLower(name))
+}
+
+// GENERATE WRAPPER ----------------------------------------------------------------------------------------------------
+
+const (
+	wrapperFilename   = "generate.sh"
+	wrapperHeaderFile = "hack/boilerplate.go.txt"
+	wrapperTemplate   = `#!/usr/bin/env bash
+# Runs the %[1]s generators on all the packages of the module. The arguments are passed to %[1]s,
+# e.g. "./%[2]s --verify" in CI.
+#
+# Set GENCMD to the path of a built %[1]s binary to skip "go run".
+set -euo pipefail
+
+cd "$(dirname "${BASH_SOURCE[0]}")"
+
+# shellcheck disable=SC2086
+exec ${GENCMD:-go run ./%[3]s} %[4]s "$@"
+`
+)
+
+// generateWrapper writes a script running the generators of the cmd, so users of the cmd don't need to know how to
+// invoke it. The script is not overwritten if it exists.
+func generateWrapper(cmd cmdFlag, generators []generatorFlag) error {
+	if err := fileShouldNotExist(wrapperFilename); err != nil {
+		if _, statErr := os.Stat(wrapperFilename); statErr != nil {
+			return err
+		}
+
+		fmt.Printf("skipped %s: file already exists\n", wrapperFilename) //nolint:forbidigo
+
+		return nil
+	}
+
+	_, err := os.Stat(wrapperHeaderFile)
+	withHeader := err == nil
+
+	opts := make([]string, 0, len(generators)+1)
+
+	for _, g := range generators {
+		// the options of the scaffolded generators are optional, the year defaults to the current one
+		if withHeader {
+			opts = append(opts, fmt.Sprintf(`'%s:headerFile=%s'`, g.name, wrapperHeaderFile))
+		} else {
+			opts = append(opts, g.name)
+		}
+	}
+
+	opts = append(opts, "paths=./...")
+
+	script := fmt.Sprintf(wrapperTemplate, cmd.name, wrapperFilename, cmd.path, strings.Join(opts, " "))
+
+	return os.WriteFile(wrapperFilename, []byte(script), 0o755) //nolint:gosec,gomnd
+}
+
+// GENERATE OUTPUT RULE ------------------------------------------------------------------------------------------------
+
+func outputRuleTypeName(name string) string {
+	return fmt.Sprintf("%sOutputRule", genutils.Title(name))
+}
+
+func outputRuleFilename(name string) string {
+	return fmt.Sprintf("%s_output.go", strings.ToLower(name))
+}
+
+func outputRuleTestFilename(name string) string {
+	return fmt.Sprintf("%s_output_test.go", strings.ToLower(name))
+}
+
+//nolint:funlen
+func generateOutputRules(outputRules []outputRuleFlag, withTests bool) error {
+	for _, o := range outputRules {
+		f := jen.NewFilePath(o.path) //nolint:varnamelen
+
+		typeName := outputRuleTypeName(o.name)
+		loaderPath := "sigs.k8s.io/controller-tools/pkg/loader"
+
+		// // MyRuleOutputRule outputs each artifact to the given directory.
+		// type MyRuleOutputRule string
+		f.Comment(fmt.Sprintf("%s outputs each artifact to the given directory.", typeName))
+		f.Type().Id(typeName).String()
+
+		// func (o MyRuleOutputRule) Open(_ *loader.Package, itemPath string) (io.WriteCloser, error) {
+		// 	// TODO: ADD YOUR CODE HERE
+		// 	path := filepath.Join(string(o), itemPath)
+		// 	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		// 		return nil, err
+		// 	}
+		//
+		// 	return os.Create(path)
+		// }
+		f.Func().
+			Params(jen.Id("o").Id(typeName)).
+			Id("Open").
+			Params(
+				jen.Id("_").Add(jen.Op("*"), jen.Qual(loaderPath, "Package")),
+				jen.Id("itemPath").String(),
+			).
+			Params(jen.Qual("io", "WriteCloser"), jen.Error()).
+			Block(
+				jen.Comment("TODO: ADD YOUR CODE HERE"),
+				jen.Id("path").Op(":=").Qual("path/filepath", "Join").
+					Call(jen.String().Call(jen.Id("o")), jen.Id("itemPath")),
+				jen.If(
+					jen.Err().Op(":=").Qual("os", "MkdirAll").Call(
+						jen.Qual("path/filepath", "Dir").Call(jen.Id("path")),
+						jen.Qual("os", "ModePerm"),
+					),
+					jen.Err().Op("!=").Nil(),
+				).Block(
+					jen.Return(jen.Nil(), jen.Err()),
+				),
+				jen.Return(jen.Qual("os", "Create").Call(jen.Id("path"))),
+			)
+
+		if err := writeFile(f, o.path, outputRuleFilename(o.name)); err != nil {
+			return err
+		}
+
+		if !withTests {
+			continue
+		}
+
+		if err := generateOutputRuleTest(o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//nolint:funlen
+func generateOutputRuleTest(o outputRuleFlag) error {
+	f := jen.NewFilePath(o.path) //nolint:varnamelen
+
+	testName := fmt.Sprintf("Test%s", outputRuleTypeName(o.name))
+	content := "hello world"
+
+	ifErrFatal := jen.If(jen.Err().Op("!=").Nil()).Block(
+		jen.Id("t").Dot("Fatal").Call(jen.Err()))
+
+	// func TestMyRuleOutputRule(t *testing.T) {
+	// 	dir := t.TempDir()
+	//
+	// 	w, err := MyRuleOutputRule(dir).Open(nil, filepath.Join("sub", "file.txt"))
+	// 	...
+	// 	b, err := os.ReadFile(filepath.Join(dir, "sub", "file.txt"))
+	// 	...
+	// 	if string(b) != "hello world" {
+	// 		t.Fatalf("expected %q, got %q", "hello world", string(b))
+	// 	}
+	// }
+	f.Func().
+		Id(testName).
+		Params(jen.Id("t").Op("*").Qual("testing", "T")).
+		Block(
+			jen.Id("dir").Op(":=").Id("t").Dot("TempDir").Call(),
+			jen.Line(),
+			jen.Comment("the output rule is expected to create missing directories."),
+			jen.List(jen.Id("w"), jen.Err()).Op(":=").Id(outputRuleTypeName(o.name)).Call(jen.Id("dir")).
+				Dot("Open").Call(jen.Nil(), jen.Qual("path/filepath", "Join").Call(jen.Lit("sub"), jen.Lit("file.txt"))),
+			ifErrFatal,
+			jen.Line(),
+			jen.If(
+				jen.List(jen.Id("_"), jen.Err()).Op(":=").Id("w").Dot("Write").
+					Call(jen.Index().Byte().Call(jen.Lit(content))),
+				jen.Err().Op("!=").Nil(),
+			).Block(jen.Id("t").Dot("Fatal").Call(jen.Err())),
+			jen.Line(),
+			jen.Comment("the content is expected to be flushed once the writer is closed."),
+			jen.If(
+				jen.Err().Op(":=").Id("w").Dot("Close").Call(),
+				jen.Err().Op("!=").Nil(),
+			).Block(jen.Id("t").Dot("Fatal").Call(jen.Err())),
+			jen.Line(),
+			jen.List(jen.Id("b"), jen.Err()).Op(":=").Qual("os", "ReadFile").
+				Call(jen.Qual("path/filepath", "Join").Call(jen.Id("dir"), jen.Lit("sub"), jen.Lit("file.txt"))),
+			ifErrFatal,
+			jen.Line(),
+			jen.If(jen.String().Call(jen.Id("b")).Op("!=").Lit(content)).Block(
+				jen.Id("t").Dot("Fatalf").Call(
+					jen.Lit("expected %q, got %q"), jen.Lit(content), jen.String().Call(jen.Id("b"))),
+			),
+		)
+
+	return writeFile(f, o.path, outputRuleTestFilename(o.name))
+}
+
+// APPEND TO CMD -------------------------------------------------------------------------------------------------------
+
+// textEdit inserts text at the given offset.
+type textEdit struct {
+	offset int
+	text   string
+}
+
+// appendToExistingCmd wires the generators and output rules in the builder chain of an existing cmd by inserting:
+// - a new const for each name,
+// - a new import for each package,
+// - a new ".WithGenerator(...)" or ".WithOutputRule(...)" call right before ".Apply()".
+//
+//nolint:funlen,cyclop
+func appendToExistingCmd(cmd cmdFlag, generators []generatorFlag, outputRules []outputRuleFlag) error {
+	fp := filepath.Join(cmd.path, cmdFilename(cmd))
+
+	src, err := os.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, fp, src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	constBlock, importBlock, applySel := findCmdAnchors(f)
+	if constBlock == nil || importBlock == nil || applySel == nil {
+		return fmt.Errorf("cannot find a const block, an import block and an \".Apply()\" call in %q", fp)
+	}
+
+	declared := make(map[string]bool)
+	for _, spec := range constBlock.Specs {
+		for _, n := range spec.(*ast.ValueSpec).Names {
+			declared[n.Name] = true
+		}
+	}
+
+	// the aliases of the imports without one are assumed to be the last element of their path
+	imported := make(map[string]string)
+	aliases := make(map[string]bool)
+
+	for _, imp := range f.Imports {
+		impPath := strings.Trim(imp.Path.Value, `"`)
+
+		alias := path.Base(impPath)
+		if imp.Name != nil {
+			alias = imp.Name.Name
+		}
+
+		imported[impPath] = alias
+		aliases[alias] = true
+	}
+
+	edits := make([]textEdit, 0)
+
+	// appendOne registers the edits wiring a single generator or output rule.
+	appendOne := func(constName, constValue, pkgDir, wiring string, wiringArgs func(pkgName string) string) error {
+		if declared[constName] {
+			return fmt.Errorf("%q is already declared in %q", constName, fp)
+		}
+
+		pkgPath, err := genutils.ResolveImportPath(pkgDir, *buildFlags...)
+		if err != nil {
+			return err
+		}
+
+		pkgName, ok := imported[pkgPath]
+		if !ok {
+			pkgName = uniqueImportAlias(importAlias(pkgPath), aliases)
+			imported[pkgPath] = pkgName
+			aliases[pkgName] = true
+			edits = append(edits, textEdit{
+				offset: offset(importBlock.Rparen),
+				text:   fmt.Sprintf("%s %q\n", pkgName, pkgPath),
+			})
+		}
+
+		declared[constName] = true
+		edits = append(edits,
+			textEdit{offset: offset(constBlock.Rparen), text: fmt.Sprintf("%s = %q\n", constName, constValue)},
+			textEdit{offset: offset(applySel.Pos()), text: fmt.Sprintf("%s(%s, %s).\n", wiring, constName, wiringArgs(pkgName))},
+		)
+
+		return nil
+	}
+
+	for _, g := range generators {
+		structName := fmt.Sprintf("%sGenerator", genutils.Title(g.name))
+
+		err := appendOne(fmt.Sprintf("%sGeneratorName", g.name), g.name, g.path, "WithGenerator",
+			func(pkgName string) string { return fmt.Sprintf("%s.%s{}", pkgName, structName) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, o := range outputRules {
+		typeName := outputRuleTypeName(o.name)
+
+		err := appendOne(fmt.Sprintf("%sOutputRuleName", o.name), o.name, o.path, "WithOutputRule",
+			func(pkgName string) string { return fmt.Sprintf("%s.%s(\"\")", pkgName, typeName) })
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeEditedFile(fp, src, edits)
+}
+
+// importAlias returns an identifier named after the last element of the import path, e.g. "generator" for
+// "example.com/mod/pkg/generator" or "mygen" for "example.com/mod/my-gen".
+func importAlias(pkgPath string) string {
+	alias := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return -1
+	}, path.Base(pkgPath))
+
+	alias = strings.TrimLeftFunc(alias, unicode.IsDigit)
+	if alias == "" || token.IsKeyword(alias) {
+		return "pkg" + alias
+	}
+
+	return alias
+}
+
+// uniqueImportAlias returns name, or name suffixed with the first number making it unique if an import of the file
+// already uses it, e.g. "generator2" when two generator packages are wired in the same cmd.
+func uniqueImportAlias(name string, aliases map[string]bool) string {
+	alias := name
+	for i := 2; aliases[alias]; i++ {
+		alias = fmt.Sprintf("%s%d", name, i)
+	}
+
+	return alias
+}
+
+// writeEditedFile applies the edits to src, then formats and writes the result to fp.
+// Edits sharing the same offset are inserted in the order they were registered.
+func writeEditedFile(fp string, src []byte, edits []textEdit) error {
+	order := make([]int, len(edits))
+	for i := range order {
+		order[i] = i
+	}
+
+	// apply the edits from the end of the file, so the offsets of the remaining edits stay valid.
+	sort.SliceStable(order, func(i, j int) bool {
+		if edits[order[i]].offset != edits[order[j]].offset {
+			return edits[order[i]].offset > edits[order[j]].offset
+		}
+
+		return order[i] > order[j]
+	})
+
+	out := src
+	for _, i := range order {
+		e := edits[i]
+		out = append(out[:e.offset:e.offset], append([]byte(e.text), out[e.offset:]...)...)
+	}
+
+	formatted, err := format.Source(out)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fp, formatted, 0644) //nolint:gosec,gofumpt
+}
+
+// findCmdAnchors returns the parenthesized const and import declarations of the cmd, and the selector of its
+// ".Apply()" call.
+func findCmdAnchors(f *ast.File) (*ast.GenDecl, *ast.GenDecl, *ast.Ident) {
+	var constBlock, importBlock *ast.GenDecl
+
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || !genDecl.Lparen.IsValid() {
+			continue
+		}
+
+		switch {
+		case genDecl.Tok == token.CONST && constBlock == nil:
+			constBlock = genDecl
+		case genDecl.Tok == token.IMPORT && importBlock == nil:
+			importBlock = genDecl
+		}
+	}
+
+	var applySel *ast.Ident
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "Apply" && applySel == nil {
+			applySel = sel.Sel
+		}
+
+		return applySel == nil
+	})
+
+	return constBlock, importBlock, applySel
+}
+
+// SCAFFOLD FROM -------------------------------------------------------------------------------------------------------
+
+// scaffoldMarker marks the packages a generator is initialized in with "--scaffold-from".
+const scaffoldMarker = "+genutils:scaffold"
+
+// skippedPackage is a package marked with scaffoldMarker in which no generator is initialized, and why.
+type skippedPackage struct {
+	path string
+	err  error
+}
+
+// findScaffoldCandidates walks the directory for the packages marked with scaffoldMarker, and returns a generator for
+// each, or the reason it is skipped, e.g. if its file already exists. Names of the given generators are not reused.
+func findScaffoldCandidates(dir string, generators []generatorFlag) ([]generatorFlag, []skippedPackage, error) {
+	scaffolded := make([]generatorFlag, 0)
+	skipped := make([]skippedPackage, 0)
+
+	names := make(map[string]bool)
+	for _, g := range generators {
+		names[g.name] = true
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		// skip the directories ignored by the go tool
+		if path != dir && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_") ||
+			d.Name() == "testdata" || d.Name() == "vendor") {
+			return filepath.SkipDir
+		}
+
+		pkgName, genName, marked, err := scaffoldMarkerOf(path)
+		if err != nil || !marked {
+			return err
+		}
+
+		// make the path a relative package pattern for the loader
+		if !filepath.IsAbs(path) && !strings.HasPrefix(path, ".") {
+			path = "." + string(filepath.Separator) + path
+		}
+
+		if genName == "" {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+
+			genName = filepath.Base(abs)
+		}
+
+		if err := scaffoldSkipReason(path, genName, names); err != nil {
+			skipped = append(skipped, skippedPackage{path: path, err: err})
+
+			return nil
+		}
+
+		names[genName] = true
+		scaffolded = append(scaffolded, generatorFlag{name: genName, path: path, pkgName: pkgName})
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return scaffolded, skipped, nil
+}
+
+// scaffoldSkipReason returns why no generator named genName can be initialized in the package at path, if so.
+func scaffoldSkipReason(path, genName string, names map[string]bool) error {
+	if err := genutils.ValidateMarkerName(genName); err != nil {
+		return err
+	}
+
+	if names[genName] {
+		return fmt.Errorf("generator %q already exists", genName)
+	}
+
+	if err := fileShouldNotExist(filepath.Join(path, generatorFilename(genName))); err != nil {
+		return fmt.Errorf("%s: %w", generatorFilename(genName), err)
+	}
+
+	return nil
+}
+
+// scaffoldMarkerOf returns the name of the package in the directory, and the generator name set by its scaffoldMarker,
+// if any. Test files are ignored.
+func scaffoldMarkerOf(dir string) (string, string, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	fset := token.NewFileSet()
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, entry.Name()), nil, parser.ParseComments)
+		if err != nil {
+			return "", "", false, err
+		}
+
+		for _, group := range f.Comments {
+			for _, comment := range group.List {
+				text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "//"))
+				if text == scaffoldMarker {
+					return f.Name.Name, "", true, nil
+				}
+
+				if value, ok := strings.CutPrefix(text, scaffoldMarker+"="); ok {
+					return f.Name.Name, strings.Trim(value, `"`), true, nil
+				}
+			}
+		}
+	}
+
+	return "", "", false, nil
+}
+
+// printScaffoldSummary prints the generators initialized with "--scaffold-from", and the skipped packages.
+func printScaffoldSummary(scaffolded []generatorFlag, skipped []skippedPackage) {
+	for _, g := range scaffolded {
+		fmt.Printf("created generator %q in %s\n", g.name, filepath.Join(g.path, generatorFilename(g.name))) //nolint:forbidigo
+	}
+
+	for _, s := range skipped {
+		fmt.Printf("skipped %s: %v\n", s.path, s.err) //nolint:forbidigo
+	}
+
+	fmt.Printf("%d generators created, %d packages skipped\n", len(scaffolded), len(skipped)) //nolint:forbidigo
+}
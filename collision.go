@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// guardedOutputRule fails to open the artifacts already written by another generator during the run, instead of
+// silently overwriting them.
+type guardedOutputRule struct {
+	genall.OutputRule
+
+	genName string
+	// written are the generators which wrote each path, shared by the rules of all the generators.
+	written map[string]string
+}
+
+func (o guardedOutputRule) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	path, err := OutputPath(o.OutputRule, pkg, itemPath)
+	if err != nil || path == "" {
+		// the rule does not write to the filesystem, or cannot tell where: nothing to guard
+		return o.OutputRule.Open(pkg, itemPath) //nolint:wrapcheck
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if other, ok := o.written[path]; ok && other != o.genName {
+		return nil, fmt.Errorf("generator %q cannot write %s: it is already written by generator %q",
+			o.genName, displayPath(path), other)
+	}
+
+	o.written[path] = o.genName
+
+	return o.OutputRule.Open(pkg, itemPath) //nolint:wrapcheck
+}
+
+func (o guardedOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputRule, pkg, itemPath)
+}
+
+// guardOutputs wraps the output rule of each generator of the runtime, so a generator fails to write a file already
+// written by another generator during the run, e.g. when two generators are configured with the same output.
+func guardOutputs(runtime *genall.Runtime, genNames []string) {
+	written := make(map[string]string)
+
+	for i, gen := range runtime.Generators {
+		runtime.OutputRules.ByGenerator[gen] = guardedOutputRule{
+			OutputRule: runtime.OutputRules.ForGenerator(gen),
+			genName:    genNames[i],
+			written:    written,
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+)
+
+// Context is the generation context given to the generators implementing ContextGenerator. It carries the
+// context.Context of the run, e.g. for cancellation or request-scoped values, along with the GenerationContext.
+type Context struct {
+	// GenerationContext is the context generators implementing only genall.Generator receive.
+	*genall.GenerationContext
+
+	ctx context.Context //nolint:containedctx
+}
+
+// Context returns the context.Context of the run.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// Value returns the value associated with the key in the context.Context of the run, or nil.
+func (c *Context) Value(key interface{}) interface{} {
+	return c.Context().Value(key)
+}
+
+// Context returns the context.Context of the run, e.g. for the run hooks, given to GenerateContext.
+func (c Cmd) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// GenerateContext runs the generators activated by the given options like Generate, with the context.Context given to
+// the generators implementing ContextGenerator and to the run hooks with Cmd.Context. Once the context is done, the
+// remaining generators are not run, and the error of the context is returned once the running generator returns.
+func (c Cmd) GenerateContext(ctx context.Context, opts []string) error {
+	c.ctx = ctx
+
+	return c.Generate(opts)
+}
+
+// generateWithContext runs the generator, with the Context of the run if it implements ContextGenerator, so it can
+// return early once ctx is done. The generator always runs to completion: the run is only aborted between generators,
+// so no generator is still writing files once the run is torn down.
+func generateWithContext(ctx context.Context, gen genall.Generator, genCtx *genall.GenerationContext) error {
+	if withContext, ok := gen.(ContextGenerator); ok {
+		return withContext.GenerateContext(&Context{GenerationContext: genCtx, ctx: ctx})
+	}
+
+	return gen.Generate(genCtx) //nolint:wrapcheck
+}
+
+// timeoutError is returned when a run exceeds its --timeout, and wraps the error of its context.
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("generation timed out after %s", e.timeout)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// deprecatedMarkerUse is a deprecated marker found by the collector.
+type deprecatedMarkerUse struct {
+	pos     token.Position
+	name    string
+	message string
+}
+
+// warnDeprecatedMarkers writes a warning to w for each deprecated marker used in the source code of the roots, as
+// deprecated with WithDeprecatedMarker or by the help of the marker (see markers.DefinitionHelp.DeprecatedInFavorOf).
+// The markers are found by the collector of the runtime, which caches them for the generators.
+func warnDeprecatedMarkers(c Cmd, runtime *genall.Runtime, w io.Writer) {
+	col := runtime.Collector
+	if col == nil || col.Registry == nil {
+		return
+	}
+
+	uses := make([]deprecatedMarkerUse, 0)
+
+	for _, root := range runtime.Roots {
+		nodeMarkers, err := col.MarkersInPackage(root)
+		if err != nil {
+			continue // the generators report the markers they cannot parse
+		}
+
+		for node, values := range nodeMarkers {
+			for name := range values {
+				message, deprecated := markerDeprecation(c, col.Registry, name, markerTarget(node))
+				if !deprecated {
+					continue
+				}
+
+				uses = append(uses, deprecatedMarkerUse{pos: nodePosition(root, node), name: name, message: message})
+			}
+		}
+	}
+
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].pos.Filename != uses[j].pos.Filename {
+			return uses[i].pos.Filename < uses[j].pos.Filename
+		}
+
+		if uses[i].pos.Line != uses[j].pos.Line {
+			return uses[i].pos.Line < uses[j].pos.Line
+		}
+
+		return uses[i].name < uses[j].name
+	})
+
+	for _, use := range uses {
+		use.pos.Filename = displayPath(use.pos.Filename)
+		_, _ = fmt.Fprintf(w, "warning: %s: marker %q is deprecated: %s\n", use.pos, "+"+use.name, use.message)
+	}
+}
+
+// markerDeprecation returns the deprecation message of the marker, and whether it is deprecated.
+func markerDeprecation(c Cmd, reg *markers.Registry, name string, target markers.TargetType) (string, bool) {
+	if message, ok := c.deprecatedMarkers[name]; ok {
+		return message, true
+	}
+
+	def := reg.Lookup("+"+name, target)
+	if def == nil {
+		return "", false
+	}
+
+	if h := reg.HelpFor(def); h != nil && h.DeprecatedInFavorOf != nil {
+		return fmt.Sprintf("please use %q instead", "+"+*h.DeprecatedInFavorOf), true
+	}
+
+	return "", false
+}
+
+// markerTarget returns the target of the markers associated to the node, like the collector does.
+func markerTarget(node ast.Node) markers.TargetType {
+	switch node.(type) {
+	case *ast.File:
+		return markers.DescribesPackage
+	case *ast.Field:
+		return markers.DescribesField
+	default:
+		return markers.DescribesType
+	}
+}
+
+// nodePosition returns the position of the node of the package, or of the package clause for package markers.
+func nodePosition(pkg *loader.Package, node ast.Node) token.Position {
+	pos := node.Pos()
+	if file, isFile := node.(*ast.File); isFile {
+		pos = file.Package
+	}
+
+	// the loader only sets the file set of the packages when type-checking them
+	if pkg.Fset != nil {
+		return pkg.Fset.Position(pos)
+	}
+
+	for i, file := range pkg.Syntax {
+		if file == nil || pos < file.FileStart || pos > file.FileEnd || i >= len(pkg.CompiledGoFiles) {
+			continue
+		}
+
+		filename := pkg.CompiledGoFiles[i]
+		offset := int(pos - file.FileStart)
+
+		src, err := os.ReadFile(filename)
+		if err != nil || offset > len(src) {
+			return token.Position{Filename: filename} //nolint:exhaustruct
+		}
+
+		line := bytes.Count(src[:offset], []byte("\n")) + 1
+		column := offset - bytes.LastIndexByte(src[:offset], '\n')
+
+		return token.Position{Filename: filename, Offset: offset, Line: line, Column: column}
+	}
+
+	return token.Position{} //nolint:exhaustruct
+}
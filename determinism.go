@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// WithDeterministic checks that the output of the generators is reproducible before each run: the generators first
+// run twice without writing anything, like GenerateToMap, and the run fails with ErrNondeterministic, listing the
+// files, if the generated files differ between both runs. This flags the generators depending on the iteration order
+// of maps, or on randomness, which break --verify.
+//
+// genutils cannot make a generator deterministic: the check only catches the differences which show up between two
+// runs of the same process, e.g. not the ones depending on the environment or on the time. Generators should iterate
+// over maps with SortedKeys. As the generators run three times, the check is meant for CI or for debugging, e.g.
+// enabled behind an environment variable.
+func (b Builder) WithDeterministic() Builder {
+	return func() Cmd {
+		g := b()
+		g.deterministic = true
+
+		return g
+	}
+}
+
+// checkDeterminism runs the generators twice without writing anything nor running the hooks, and returns an error
+// wrapping ErrNondeterministic if the generated files differ. Failed runs are not compared, as the actual run reports
+// their errors.
+func checkDeterminism(c Cmd, opts []string) error {
+	c.deterministic = false
+	c.preRuns = nil
+	c.progress = false
+	c.errorReport = ""
+	c.metrics = ""
+	c.markerDocs = ""
+
+	first, err := c.GenerateToMap(opts)
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+
+	second, err := c.GenerateToMap(opts)
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+
+	differ := make([]string, 0)
+
+	for _, path := range SortedKeys(first) {
+		if content, ok := second[path]; !ok || !bytes.Equal(content, first[path]) {
+			differ = append(differ, displayPath(path))
+		}
+	}
+
+	for _, path := range SortedKeys(second) {
+		if _, ok := first[path]; !ok {
+			differ = append(differ, displayPath(path))
+		}
+	}
+
+	if len(differ) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrNondeterministic, strings.Join(differ, ", "))
+}
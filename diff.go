@@ -0,0 +1,201 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines printed around each change.
+	diffContext = 3
+	// maxDiffCells bounds the size of the table used to compute the longest common subsequence. Larger changes are
+	// printed as a single replacement.
+	maxDiffCells = 1 << 22
+)
+
+// diffLine is a line of a diff, prefixed with ' ', '-' or '+'.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// writeUnifiedDiff writes the unified diff turning a into b.
+func writeUnifiedDiff(w io.Writer, fromName, toName string, a, b []byte) {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	_, _ = fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(lines); {
+		// find the next change
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+
+		if start == len(lines) {
+			break
+		}
+
+		// extend the hunk until the changes are separated by more than twice the context
+		end, unchanged := start, 0
+		for i := start; i < len(lines) && unchanged <= 2*diffContext; i++ {
+			if lines[i].op == ' ' {
+				unchanged++
+
+				continue
+			}
+
+			unchanged, end = 0, i+1
+		}
+
+		writeHunk(w, lines, max(0, start-diffContext), min(len(lines), end+diffContext))
+
+		start = end
+	}
+}
+
+// writeHunk writes the lines [from, to) as a hunk.
+func writeHunk(w io.Writer, lines []diffLine, from, to int) {
+	aStart, bStart := 1, 1
+
+	for _, line := range lines[:from] {
+		if line.op != '+' {
+			aStart++
+		}
+
+		if line.op != '-' {
+			bStart++
+		}
+	}
+
+	aLen, bLen := 0, 0
+
+	for _, line := range lines[from:to] {
+		if line.op != '+' {
+			aLen++
+		}
+
+		if line.op != '-' {
+			bLen++
+		}
+	}
+
+	// an empty range starts at the line before it
+	if aLen == 0 {
+		aStart--
+	}
+
+	if bLen == 0 {
+		bStart--
+	}
+
+	_, _ = fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+
+	for _, line := range lines[from:to] {
+		_, _ = fmt.Fprintf(w, "%c%s\n", line.op, line.text)
+	}
+}
+
+// diffLines returns the lines of a and b as a sequence of unchanged, removed and added lines.
+func diffLines(a, b []string) []diffLine {
+	// strip the common prefix and suffix, so the table only covers the changed part
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{op: ' ', text: text})
+	}
+
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{op: ' ', text: text})
+	}
+
+	return lines
+}
+
+// diffMiddle diffs a and b using the longest common subsequence of their lines.
+func diffMiddle(a, b []string) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, text := range a {
+			lines = append(lines, diffLine{op: '-', text: text})
+		}
+
+		for _, text := range b {
+			lines = append(lines, diffLine{op: '+', text: text})
+		}
+
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{op: ' ', text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{op: '-', text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{op: '+', text: b[j]})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// splitLines splits the content into lines, without their line terminator.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))), "\n"), "\n")
+}
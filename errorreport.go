@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// reportedError is an error recorded on a root during the run, as written by --error-report.
+type reportedError struct {
+	Package   string `json:"package"`
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Message   string `json:"message"`
+	Generator string `json:"generator,omitempty"`
+}
+
+// errorOrigins records the name of the generator which added each error of the roots, by index. Errors added outside
+// of a generator, e.g. when loading the packages, have no generator.
+type errorOrigins map[*loader.Package][]string
+
+// record attributes the errors added to the roots since the last call to the given generator.
+func (o errorOrigins) record(roots []*loader.Package, genName string) {
+	for _, root := range roots {
+		for len(o[root]) < len(root.Errors) {
+			o[root] = append(o[root], genName)
+		}
+	}
+}
+
+// writeErrorReport writes the errors of the roots to the file at path as a JSON array, e.g. for CI to annotate the
+// sources. Type errors are skipped, like when the errors are printed, as they're probably just from partial type
+// checking.
+func writeErrorReport(path string, roots []*loader.Package, origins errorOrigins) error {
+	report := make([]reportedError, 0)
+
+	for _, root := range roots {
+		for i, err := range root.Errors {
+			if err.Kind == packages.TypeError {
+				continue
+			}
+
+			reported := reportedError{Package: root.PkgPath, Message: err.Msg} //nolint:exhaustruct
+			reported.File, reported.Line, reported.Column = parseErrorPos(err.Pos)
+
+			if reported.File != "" {
+				reported.File = displayPath(reported.File)
+			}
+
+			if i < len(origins[root]) {
+				reported.Generator = origins[root][i]
+			}
+
+			report = append(report, reported)
+		}
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil { //nolint:gosec,gomnd
+		return fmt.Errorf("writing the error report: %w", err)
+	}
+
+	return nil
+}
+
+// parseErrorPos parses the position of a packages.Error, "file:line:column", "file:line" or "file", into its parts.
+// Positions without a file, e.g. "-" for errors not associated to a source file, yield an empty file.
+func parseErrorPos(pos string) (string, int, int) {
+	if pos == "" || pos == "-" {
+		return "", 0, 0
+	}
+
+	numbers := make([]int, 0, 2) //nolint:gomnd
+
+	for len(numbers) < 2 {
+		i := strings.LastIndex(pos, ":")
+		if i < 0 {
+			break
+		}
+
+		n, err := strconv.Atoi(pos[i+1:])
+		if err != nil {
+			// e.g. the "ID:-" of the errors the loader cannot place in a file
+			if pos[i+1:] == "-" {
+				return "", 0, 0
+			}
+
+			break
+		}
+
+		numbers = append([]int{n}, numbers...)
+		pos = pos[:i]
+	}
+
+	switch len(numbers) {
+	case 2: //nolint:gomnd
+		return pos, numbers[0], numbers[1]
+	case 1:
+		return pos, numbers[0], 0
+	default:
+		return pos, 0, 0
+	}
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of commands built with genutils.
+const (
+	// ExitCodeSuccess is returned when the command succeeded, including when printing the help or the version.
+	ExitCodeSuccess = 0
+	// ExitCodeInternal is returned on unexpected errors.
+	ExitCodeInternal = 1
+	// ExitCodeUsage is returned when the command is misused: bad flags, unknown or malformed options, no generators
+	// specified, or packages that cannot be loaded.
+	ExitCodeUsage = 2
+	// ExitCodeGeneration is returned when not all generators ran successfully, or when their output is not
+	// deterministic (see Builder.WithDeterministic).
+	ExitCodeGeneration = 3
+	// ExitCodeOutOfDate is returned by --verify when the generated files on disk are out of date.
+	ExitCodeOutOfDate = 4
+	// ExitCodeUnknownMarkers is returned by the lint subcommand when unknown markers are found in the source code.
+	ExitCodeUnknownMarkers = 5
+)
+
+var (
+	// ErrNoGenerators is returned when the options do not activate any generator, wrapped in a NoGeneratorsError.
+	ErrNoGenerators = errors.New("no generators specified")
+	// ErrGeneration is returned when not all generators ran successfully.
+	ErrGeneration = errors.New("not all generators ran successfully")
+	// ErrNondeterministic is returned by the commands built with WithDeterministic when the generated files differ
+	// between two runs.
+	ErrNondeterministic = errors.New("generated files differ between runs")
+	// ErrOutOfDate is returned by --verify when the generated files on disk are out of date.
+	ErrOutOfDate = errors.New("generated files are out of date")
+	// ErrUnknownMarkers is returned by the lint subcommand when unknown markers are found in the source code.
+	ErrUnknownMarkers = errors.New("unknown markers found")
+)
+
+// ExitCode returns the exit code corresponding to the error returned by a command built with genutils.
+func ExitCode(err error) int {
+	var (
+		usageErr            *UsageError
+		unknownGeneratorErr *UnknownGeneratorError
+		optionParseErr      *OptionParseError
+		loadErr             *LoadError
+	)
+
+	switch {
+	case err == nil:
+		return ExitCodeSuccess
+	case errors.Is(err, ErrGeneration), errors.Is(err, ErrNondeterministic):
+		return ExitCodeGeneration
+	case errors.Is(err, ErrOutOfDate):
+		return ExitCodeOutOfDate
+	case errors.Is(err, ErrUnknownMarkers):
+		return ExitCodeUnknownMarkers
+	case errors.Is(err, ErrNoGenerators),
+		errors.As(err, &usageErr),
+		errors.As(err, &unknownGeneratorErr),
+		errors.As(err, &optionParseErr),
+		errors.As(err, &loadErr):
+		return ExitCodeUsage
+	default:
+		return ExitCodeInternal
+	}
+}
+
+// UsageError is returned when the flags of the command are invalid.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownGeneratorError is returned when an option refers to a generator that is not registered.
+type UnknownGeneratorError struct {
+	// Name is the name of the generator as specified by the user.
+	Name string
+	// Suggestion is the name of the closest registered generator, if any is close enough.
+	Suggestion string
+}
+
+func (e *UnknownGeneratorError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown generator %q, did you mean %q?", e.Name, e.Suggestion)
+	}
+
+	return fmt.Sprintf("unknown generator %q", e.Name)
+}
+
+// NoGeneratorsError is returned when the options do not activate any generator. It matches ErrNoGenerators with
+// errors.Is.
+type NoGeneratorsError struct {
+	// Available are the names of the registered generators, sorted.
+	Available []string
+	// Suggestion is the name of the registered generator closest to one of the options, if any is close enough, e.g.
+	// when the name of a generator is mistyped as the one of another option.
+	Suggestion string
+}
+
+func (e *NoGeneratorsError) Error() string {
+	msg := ErrNoGenerators.Error()
+
+	if len(e.Available) > 0 {
+		msg = fmt.Sprintf("%s, available generators: %s", msg, strings.Join(e.Available, ", "))
+	}
+
+	if e.Suggestion != "" {
+		msg = fmt.Sprintf("%s; did you mean %q?", msg, e.Suggestion)
+	}
+
+	return msg
+}
+
+func (e *NoGeneratorsError) Unwrap() error {
+	return ErrNoGenerators
+}
+
+// OptionParseError is returned when an option is malformed, is not known, or is inconsistent with other options.
+type OptionParseError struct {
+	// Option is the raw option as specified by the user.
+	Option string
+	Err    error
+}
+
+func (e *OptionParseError) Error() string {
+	return fmt.Sprintf("unable to parse option %q: %s", e.Option, e.Err)
+}
+
+func (e *OptionParseError) Unwrap() error {
+	return e.Err
+}
+
+// LoadError is returned when the packages to generate for cannot be loaded, e.g. when a path does not exist.
+type LoadError struct {
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("unable to load packages: %s", e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateError is returned when a template fails to execute.
+type TemplateError struct {
+	// Name is the name of the template.
+	Name string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("unable to execute template %q: %s", e.Name, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// newUnknownOptionError returns an UnknownGeneratorError if the unknown option refers to a generator that is not
+// registered, or an OptionParseError otherwise.
+func newUnknownOptionError(c Cmd, option string) error {
+	name, _, _ := strings.Cut(option, "=")
+
+	if _, _, isOutput := parseOutputMarkerName(name); !isOutput {
+		parts := strings.Split(name, markerNameSeparator)
+		if c.markerNamespace != "" && parts[0] == c.markerNamespace && len(parts) > 1 {
+			return newUnknownGeneratorError(c, strings.Join(parts[:2], markerNameSeparator))
+		}
+
+		return newUnknownGeneratorError(c, parts[0])
+	}
+
+	if genName, _, _ := parseOutputMarkerName(name); genName != "" {
+		if _, ok := c.generators[genName]; !ok {
+			return newUnknownGeneratorError(c, genName)
+		}
+	}
+
+	return &OptionParseError{Option: option, Err: fmt.Errorf("unknown output rule %q", name)}
+}
+
+// newUnknownGeneratorError returns an UnknownGeneratorError suggesting the closest registered generator.
+func newUnknownGeneratorError(c Cmd, name string) *UnknownGeneratorError {
+	candidates := append(SortedKeys(c.generators), SortedKeys(c.markerAliases)...)
+
+	return &UnknownGeneratorError{Name: name, Suggestion: closest(name, candidates)}
+}
+
+// newNoGeneratorsError returns a NoGeneratorsError listing the registered generators, and suggesting the one closest
+// to the name of an option, if any.
+func newNoGeneratorsError(c Cmd, opts []string) *NoGeneratorsError {
+	available := SortedKeys(c.generators)
+
+	suggestion := ""
+
+	for _, opt := range opts {
+		name, _, _ := strings.Cut(strings.TrimPrefix(opt, "+"), "=")
+
+		if suggestion = closest(name, available); suggestion != "" {
+			break
+		}
+	}
+
+	return &NoGeneratorsError{Available: available, Suggestion: suggestion}
+}
+
+// closest returns the candidate with the smallest Levenshtein distance to s, or an empty string if none is close
+// enough to be a plausible typo.
+func closest(s string, candidates []string) string {
+	// allow roughly one typo every 3 characters, and at least 2.
+	threshold := max(2, len(s)/3) //nolint:gomnd
+	best, bestDistance := "", threshold+1
+
+	for _, candidate := range candidates {
+		if d := levenshtein(s, candidate); d < bestDistance && d < len(candidate) {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
+
+// levenshtein returns the minimum number of single-rune edits required to change a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr := make([]int, len(rb)+1)
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = curr
+	}
+
+	return prev[len(rb)]
+}
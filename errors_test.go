@@ -0,0 +1,137 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	errCause := errors.New("cause")
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		// as reports whether errors.As finds the typed error in err.
+		as func(err error) bool
+		// is is the error err matches with errors.Is, if any.
+		is       error
+		exitCode int
+	}{
+		{
+			name:     "UsageError",
+			err:      &UsageError{Err: errCause},
+			as:       func(err error) bool { var target *UsageError; return errors.As(err, &target) },
+			is:       errCause,
+			exitCode: ExitCodeUsage,
+		},
+		{
+			name:     "UnknownGeneratorError",
+			err:      &UnknownGeneratorError{Name: "objet", Suggestion: "object"},
+			as:       func(err error) bool { var target *UnknownGeneratorError; return errors.As(err, &target) },
+			exitCode: ExitCodeUsage,
+		},
+		{
+			name:     "NoGeneratorsError",
+			err:      &NoGeneratorsError{Available: []string{"object"}},
+			as:       func(err error) bool { var target *NoGeneratorsError; return errors.As(err, &target) },
+			is:       ErrNoGenerators,
+			exitCode: ExitCodeUsage,
+		},
+		{
+			name:     "OptionParseError",
+			err:      &OptionParseError{Option: "object:foo", Err: errCause},
+			as:       func(err error) bool { var target *OptionParseError; return errors.As(err, &target) },
+			is:       errCause,
+			exitCode: ExitCodeUsage,
+		},
+		{
+			name:     "LoadError",
+			err:      &LoadError{Err: errCause},
+			as:       func(err error) bool { var target *LoadError; return errors.As(err, &target) },
+			is:       errCause,
+			exitCode: ExitCodeUsage,
+		},
+		{
+			name:     "TemplateError",
+			err:      &TemplateError{Name: "tmpl", Err: errCause},
+			as:       func(err error) bool { var target *TemplateError; return errors.As(err, &target) },
+			is:       errCause,
+			exitCode: ExitCodeInternal,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the commands return the typed errors as is, wrapped, or wrapped in a noUsageError
+			for _, err := range []error{tc.err, fmt.Errorf("wrapped: %w", tc.err), noUsageError{tc.err}} {
+				if !tc.as(err) {
+					t.Errorf("errors.As(%v) = false, want true", err)
+				}
+
+				if tc.is != nil && !errors.Is(err, tc.is) {
+					t.Errorf("errors.Is(%v, %v) = false, want true", err, tc.is)
+				}
+
+				if got := ExitCode(err); got != tc.exitCode {
+					t.Errorf("ExitCode(%v) = %d, want %d", err, got, tc.exitCode)
+				}
+			}
+		})
+	}
+}
+
+func TestClosest(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		s          string
+		candidates []string
+		want       string
+	}{
+		{name: "typo", s: "objet", candidates: []string{"crd", "object", "rbac"}, want: "object"},
+		{name: "transposition", s: "rbca", candidates: []string{"crd", "object", "rbac"}, want: "rbac"},
+		{name: "closest of several", s: "webhok", candidates: []string{"webhooks", "webhook"}, want: "webhook"},
+		{name: "unrelated", s: "schemapatch", candidates: []string{"crd", "object", "rbac"}, want: ""},
+		// the threshold of a 9 characters name is max(2, 9/3) = 3 edits
+		{name: "at the threshold", s: "abcdefghi", candidates: []string{"abcdefxyz"}, want: "abcdefxyz"},
+		{name: "above the threshold", s: "abcdefghi", candidates: []string{"abcdewxyz"}, want: ""},
+		// the threshold of short names is at least 2 edits, but a candidate can't be replaced entirely
+		{name: "short name", s: "ob", candidates: []string{"obj"}, want: "obj"},
+		{name: "replaced entirely", s: "ab", candidates: []string{"xy"}, want: ""},
+		{name: "no candidates", s: "object", want: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := closest(tc.s, tc.candidates); got != tc.want {
+				t.Errorf("closest(%q, %q) = %q, want %q", tc.s, tc.candidates, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnknownGeneratorSuggestion(t *testing.T) {
+	c := New("test").
+		WithGenerator("object", NewSimpleGenerator(nil, nil)).
+		WithGenerator("rbac", NewSimpleGenerator(nil, nil))()
+
+	if err := newUnknownGeneratorError(c, "objet"); err.Suggestion != "object" {
+		t.Errorf("expected the suggestion %q, got %q", "object", err.Suggestion)
+	}
+
+	if err := newUnknownGeneratorError(c, "schemapatch"); err.Suggestion != "" {
+		t.Errorf("expected no suggestion, got %q (%v)", err.Suggestion, err)
+	}
+}
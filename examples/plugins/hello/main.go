@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command hello is a reference implementation of the genutils plugin protocol.
+//
+// It generates a Greeting method for each type annotated with "+hello:greeting=<text>":
+//
+//	go build -o ./plugins/hello ./examples/plugins/hello
+//	<your-cmd> hello paths=./...
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alexandremahdhaoui/genutils"
+)
+
+const (
+	greetingMarker = "hello:greeting"
+	filename       = "zz_generated.hello.go"
+)
+
+func main() {
+	if len(os.Args) != 2 { //nolint:gomnd
+		_, _ = fmt.Fprintln(os.Stderr, "usage: hello describe|generate")
+		os.Exit(2) //nolint:gomnd
+	}
+
+	var (
+		response interface{}
+		err      error
+	)
+
+	switch os.Args[1] {
+	case "describe":
+		response = describe()
+	case "generate":
+		response, err = generate()
+	default:
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+
+	if err == nil {
+		err = json.NewEncoder(os.Stdout).Encode(response)
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func describe() genutils.PluginDescription {
+	return genutils.PluginDescription{
+		ProtocolVersion: genutils.PluginProtocolVersion,
+		Help: &genutils.PluginHelp{
+			Category: "hello",
+			Summary:  "generates a Greeting method for annotated types.",
+		},
+		Markers: []genutils.PluginMarkerDescription{{
+			Name:   greetingMarker,
+			Target: "type",
+			Help: &genutils.PluginHelp{
+				Category: "hello",
+				Summary:  "sets the text returned by the Greeting method of the type.",
+			},
+		}},
+	}
+}
+
+func generate() (genutils.PluginResponse, error) {
+	request := genutils.PluginRequest{}
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		return genutils.PluginResponse{}, err //nolint:wrapcheck
+	}
+
+	response := genutils.PluginResponse{}
+
+	for _, root := range request.Roots {
+		buffer := new(bytes.Buffer)
+
+		for _, m := range root.Markers {
+			if m.Name != greetingMarker {
+				continue
+			}
+
+			greeting, ok := m.Value.(string)
+			if !ok {
+				response.Errors = append(response.Errors, genutils.PluginError{
+					Root:    root.ID,
+					Message: fmt.Sprintf("type %s: %s expects a string", m.Type, greetingMarker),
+				})
+
+				continue
+			}
+
+			_, _ = fmt.Fprintf(buffer, "\nfunc (%[1]s %[2]s) Greeting() string {\n\treturn %[3]q\n}\n",
+				genutils.ReceiverName(m.Type), m.Type, greeting)
+		}
+
+		if buffer.Len() == 0 {
+			continue
+		}
+
+		response.Files = append(response.Files, genutils.PluginFile{
+			Root:    root.ID,
+			Path:    filename,
+			Content: fmt.Sprintf("// Code generated by hello. DO NOT EDIT.\n\npackage %s\n%s", root.Name, buffer),
+		})
+	}
+
+	return response, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-tools/pkg/genall/help"
+	prettyhelp "sigs.k8s.io/controller-tools/pkg/genall/help/pretty"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// ExplainMarker writes the detailed help of the marker registered by the generators of the command with the given
+// name, e.g. "yourgen:foo", including its arguments and their types. The name may be written as in the source code,
+// e.g. "+yourgen:foo=bar". If no marker has this name, the marker whose name contains it is explained, if it is the
+// only one. Otherwise, a *UsageError suggests the closest markers.
+func (c Cmd) ExplainMarker(w io.Writer, name string) error {
+	c.ensureRegistered()
+
+	reg, err := markerDocsRegistry(c, SortedKeys(c.generators))
+	if err != nil {
+		return err
+	}
+
+	name, _, _ = strings.Cut(strings.TrimPrefix(strings.TrimSpace(name), "+"), "=")
+
+	defs, err := lookupMarkerDefinitions(reg, name)
+	if err != nil {
+		return err
+	}
+
+	explained := &markers.Registry{}
+
+	for _, def := range defs {
+		if err := explained.Register(def); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if h := reg.HelpFor(def); h != nil {
+			explained.AddHelp(def, h)
+		}
+	}
+
+	for _, cat := range help.ByCategory(explained, help.SortByCategory) {
+		if err := prettyhelp.MarkersDetails(true, cat.Category, cat.Markers).WriteTo(w); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	return nil
+}
+
+// lookupMarkerDefinitions returns the definitions of the marker with the given name, for every target, or of the only
+// marker whose name contains it.
+func lookupMarkerDefinitions(reg *markers.Registry, name string) ([]*markers.Definition, error) {
+	byName := make(map[string][]*markers.Definition)
+	for _, def := range reg.AllDefinitions() {
+		byName[def.Name] = append(byName[def.Name], def)
+	}
+
+	if defs, ok := byName[name]; ok {
+		return defs, nil
+	}
+
+	matching := make([]string, 0)
+
+	for _, known := range SortedKeys(byName) {
+		if name != "" && strings.Contains(known, name) {
+			matching = append(matching, known)
+		}
+	}
+
+	switch {
+	case len(matching) == 1:
+		return byName[matching[0]], nil
+	case len(matching) > 1:
+		return nil, &UsageError{Err: fmt.Errorf("marker %q is ambiguous, did you mean one of %s?", name,
+			strings.Join(matching, ", "))}
+	}
+
+	if suggestion := closest(name, SortedKeys(byName)); suggestion != "" {
+		return nil, &UsageError{Err: fmt.Errorf("unknown marker %q, did you mean %q?", name, suggestion)}
+	}
+
+	return nil, &UsageError{Err: fmt.Errorf("unknown marker %q", name)}
+}
+
+// explainCmd returns the "explain" subcommand, printing the detailed help of a single marker.
+func (c Cmd) explainCmd() *cobra.Command {
+	return &cobra.Command{ //nolint:exhaustruct,exhaustivestruct
+		Use:   "explain <marker>",
+		Short: "print the detailed help of a single marker",
+		Long: "print the detailed help of a single marker registered by the generators, e.g. \"yourgen:foo\", " +
+			"including its arguments and their types",
+		Args: cobra.ExactArgs(1),
+		RunE: func(ccmd *cobra.Command, args []string) error {
+			if err := c.ExplainMarker(ccmd.OutOrStdout(), args[0]); err != nil {
+				return noUsageError{err}
+			}
+
+			return nil
+		},
+		SilenceUsage: true,
+	}
+}
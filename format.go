@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// FormatOffSentinel starts a region of the content passed to WriteFile which is preserved verbatim by the
+	// formatting. The region ends with FormatOnSentinel. Both sentinels must be on their own line, and are stripped
+	// from the output.
+	//
+	// Generally useful for intentionally-spaced content embedded in generated Go files, e.g. YAML in a raw string.
+	FormatOffSentinel = "// genutils:format:off"
+	// FormatOnSentinel ends a region started by FormatOffSentinel.
+	FormatOnSentinel = "// genutils:format:on"
+
+	regionPlaceholder = "//genutils:region:%d"
+)
+
+// protectRegions replaces each region delimited by the sentinels, sentinels included, with a placeholder comment, and
+// returns the original content of the regions.
+func protectRegions(src []byte) ([]byte, [][]byte, error) {
+	if !bytes.Contains(src, []byte(FormatOffSentinel)) && !bytes.Contains(src, []byte(FormatOnSentinel)) {
+		return src, nil, nil
+	}
+
+	out := new(bytes.Buffer)
+	regions := make([][]byte, 0)
+
+	var region [][]byte
+
+	for i, line := range bytes.SplitAfter(src, []byte("\n")) {
+		switch string(bytes.TrimSpace(line)) {
+		case FormatOffSentinel:
+			if region != nil {
+				return nil, nil, fmt.Errorf("line %d: nested %q", i+1, FormatOffSentinel)
+			}
+
+			region = make([][]byte, 0)
+		case FormatOnSentinel:
+			if region == nil {
+				return nil, nil, fmt.Errorf("line %d: %q without a preceding %q", i+1, FormatOnSentinel, FormatOffSentinel)
+			}
+
+			_, _ = fmt.Fprintf(out, regionPlaceholder+"\n", len(regions))
+			regions = append(regions, bytes.Join(region, nil))
+			region = nil
+		default:
+			if region != nil {
+				region = append(region, line)
+
+				continue
+			}
+
+			out.Write(line)
+		}
+	}
+
+	if region != nil {
+		return nil, nil, fmt.Errorf("%q without a following %q", FormatOffSentinel, FormatOnSentinel)
+	}
+
+	return out.Bytes(), regions, nil
+}
+
+// restoreRegions replaces each placeholder line with the original content of its region.
+func restoreRegions(src []byte, regions [][]byte) []byte {
+	if len(regions) == 0 {
+		return src
+	}
+
+	out := new(bytes.Buffer)
+
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		restored := false
+
+		for i, region := range regions {
+			if string(bytes.TrimSpace(line)) == fmt.Sprintf(regionPlaceholder, i) {
+				out.Write(region)
+
+				restored = true
+
+				break
+			}
+		}
+
+		if !restored {
+			out.Write(line)
+		}
+	}
+
+	return out.Bytes()
+}
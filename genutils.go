@@ -18,15 +18,34 @@ package genutils
 
 //nolint:depguard
 import (
+	"bufio"
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/build/constraint"
 	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
 	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/controller-tools/pkg/genall"
@@ -53,25 +72,159 @@ type (
 
 		// markerRegistry contains all the marker definitions used to process command line options.
 		markerRegistry *markers.Registry
+		// registerOnce ensures the marker definitions are registered only once.
+		registerOnce *sync.Once
 
 		// outputRules defines the list of all known output rules, giving them names for use on the command line.
 		// Each output rule turns into two command line options:
 		// - output:<generator>:<form> (per-generator output)
 		// - output:<form> (default output)
 		outputRules map[string]genall.OutputRule
+
+		// defaultOptions are prepended to the options of every run, unless overridden by the user.
+		defaultOptions []string
+
+		// preRuns and postRuns are hooks invoked respectively before and after the generators run.
+		preRuns  []RunHook
+		postRuns []RunHook
+
+		// keepGoing reports generation errors without failing the run.
+		keepGoing bool
+
+		// only and skip restrict the generators activated by the options, see selectGenerators.
+		only []string
+		skip []string
+
+		// exclude are the glob patterns of the import paths of the packages not to generate for.
+		exclude []string
+		// buildFlags are passed to the build system when loading the packages, see WithBuildFlags.
+		buildFlags []string
+		// rootDir is the directory the packages are loaded and the relative output paths resolved from, if not the
+		// working directory.
+		rootDir string
+		// markerSources are the parsers of the markers of the files which are not Go source code, by extension.
+		markerSources map[string]MarkerParser
+		// noFormat disables the formatting of the files written with WriteFile.
+		noFormat bool
+		// skipEmpty skips writing the files written with WriteFile whose content is empty.
+		skipEmpty bool
+
+		// incremental only generates for the packages with source files newer than their generated files.
+		incremental bool
+
+		// progress reports how many generators completed out of the total.
+		progress bool
+
+		// verify generates into memory and compares the output with the files on disk, instead of writing them.
+		verify bool
+		// diff generates into memory and prints the diff with the files on disk, instead of writing them.
+		diff bool
+
+		// verbose logs the output rule selected for each artifact, and its destination.
+		verbose bool
+
+		// generatorStdout is where what the generators print to the standard output is redirected during the run,
+		// see WithGeneratorStdout.
+		generatorStdout io.Writer
+
+		// errorReport is the path the errors recorded on the roots are written to as JSON, if any.
+		errorReport string
+		// metrics is the path the timing and the file counts of the run are written to, if any.
+		metrics string
+
+		// markerDocs is the path the Markdown documentation of the markers used by the run is written to, if any.
+		markerDocs string
+
+		// markerAliases maps deprecated generator names to their new names.
+		markerAliases map[string]string
+
+		// deprecatedMarkers maps the names of deprecated markers to their deprecation message.
+		deprecatedMarkers map[string]string
+
+		// outputRuleAliases maps alternative names of output rules to the names they were registered with.
+		outputRuleAliases map[string]string
+
+		// markerCategories maps generator names to the category of the markers they register in the help.
+		markerCategories map[string]string
+
+		// markerNamespace prefixes the names of the markers of the generators and output rules, see
+		// WithMarkerNamespace.
+		markerNamespace string
+
+		// withoutOptionsMarkers disables the registration of the common options markers (e.g. "paths").
+		withoutOptionsMarkers bool
+
+		// pluginDirs are the directories the plugins are discovered from.
+		pluginDirs []string
+
+		// cache holds the packages loaded by the previous runs, if enabled.
+		cache *collectorCache
+
+		// roots are the packages to generate for, instead of loading the paths, see GeneratePackages.
+		roots []*loader.Package
+
+		// errorHint returns the hint printed after an error, see WithErrorHint.
+		errorHint func(cmd *cobra.Command) string
+
+		// helpSort sorts the markers in the help, if set, see WithHelpSort.
+		helpSort help.SortGroup
+
+		// capture collects the generated files instead of writing them, see GenerateToMap.
+		capture *capture
+
+		// result records the files written by the run, see GenerateWithResult.
+		result *Result
+
+		// deterministic checks that the generated files do not differ between runs, see WithDeterministic.
+		deterministic bool
+
+		// ctx is the context of the run, see GenerateContext.
+		ctx context.Context //nolint:containedctx
+
+		// timeout aborts the run once exceeded, if positive.
+		timeout time.Duration
 	}
 
+	// Builder builds a Cmd. Each call builds a new Cmd from scratch, copying the maps it is given, so the builders
+	// derived from a common one, e.g. with Extend, never share their generators, output rules or markers.
 	Builder func() Cmd
+
+	// RunHook is invoked with the command and the options it runs with.
+	RunHook func(c Cmd, opts []string) error
+
+	// Finalizer is an optional interface implemented by generators needing to run after all generators processed
+	// every root, e.g. to write an index of everything they emitted.
+	Finalizer interface {
+		Finalize(ctx *genall.GenerationContext) error
+	}
+
+	// ContextGenerator is an optional interface implemented by generators needing the context.Context of the run, e.g.
+	// a cancellation signal or request-scoped values set with Cmd.GenerateContext. GenerateContext is invoked instead of
+	// Generate, which is still required by genall.Generator.
+	ContextGenerator interface {
+		genall.Generator
+		GenerateContext(ctx *Context) error
+	}
 )
 
+// New returns a builder for a command with the given name. The name may be empty for commands embedded in a parent
+// command with Cmd.AddTo, which supplies it, but a command must have a name to Run standalone.
 func New(name string) Builder {
 	return func() Cmd {
 		return Cmd{
-			name:           name,
-			generators:     make(map[string]genall.Generator),
-			markerRegistry: &markers.Registry{},
+			name:              name,
+			generators:        make(map[string]genall.Generator),
+			markerRegistry:    &markers.Registry{},
+			registerOnce:      &sync.Once{},
+			markerAliases:     make(map[string]string),
+			markerCategories:  make(map[string]string),
+			deprecatedMarkers: make(map[string]string),
+			outputRuleAliases: make(map[string]string),
+			markerSources:     make(map[string]MarkerParser),
+			errorHint:         defaultErrorHint,
 			outputRules: map[string]genall.OutputRule{
 				"dir":    genall.OutputToDirectory(""),
+				"module": OutputToModuleRoot,
 				"stdout": genall.OutputToStdout,
 			},
 		}
@@ -105,10 +258,39 @@ func (b Builder) WithGenerator(key string, generator genall.Generator) Builder {
 	}
 }
 
+// WithGeneratorIf registers the generator under the given key only if enabled returns true, e.g. depending on an
+// environment variable or on the presence of a file. The predicate is evaluated once, when the command is built with
+// Apply: a disabled generator is unknown to the command, as if it was never registered.
+func (b Builder) WithGeneratorIf(key string, generator genall.Generator, enabled func() bool) Builder {
+	return func() Cmd {
+		g := b()
+		if enabled() {
+			g.generators[key] = generator
+		}
+
+		return g
+	}
+}
+
+// WithGeneratorKeys registers the same generator under each of the given keys, e.g. under a legacy name and a new one.
+// Each key gets its own options and output rule markers, showing the same help. Unlike WithMarkerAlias, no key is
+// deprecated.
+func (b Builder) WithGeneratorKeys(generator genall.Generator, keys ...string) Builder {
+	return func() Cmd {
+		g := b()
+		for _, key := range keys {
+			g.generators[key] = generator
+		}
+
+		return g
+	}
+}
+
+// WithGenerators replaces the registered generators with a copy of the given ones, so the map can be shared.
 func (b Builder) WithGenerators(generators map[string]genall.Generator) Builder {
 	return func() Cmd {
 		g := b()
-		g.generators = generators
+		g.generators = maps.Clone(generators)
 
 		return g
 	}
@@ -123,52 +305,235 @@ func (b Builder) WithOutputRule(key string, outputRule genall.OutputRule) Builde
 	}
 }
 
+// WithGeneratorStdout redirects what the generators print directly to the standard output (e.g. with fmt.Println) to
+// w during the run, e.g. to a buffer to capture it. Output rules writing to the standard output are not affected.
+//
+// By default, it is redirected to the standard error when an output rule writes to the standard output, so the
+// generated content can be piped without the logs of the generators. Use os.Stdout to disable the redirection.
+func (b Builder) WithGeneratorStdout(w io.Writer) Builder {
+	return func() Cmd {
+		g := b()
+		g.generatorStdout = w
+
+		return g
+	}
+}
+
+// WithOutputRuleAlias makes the output rule registered as canonical (e.g. "dir") also available under the alias, e.g.
+// "output:d=..." for "output:dir=...", along with its per-generator forms. The canonical rule must be registered,
+// including with WithOutputRule.
+func (b Builder) WithOutputRuleAlias(alias, canonical string) Builder {
+	return func() Cmd {
+		g := b()
+		g.outputRuleAliases[alias] = canonical
+
+		return g
+	}
+}
+
+// WithOutputRules replaces the registered output rules, including the default ones, with a copy of the given ones, so
+// the map can be shared.
 func (b Builder) WithOutputRules(outputRules map[string]genall.OutputRule) Builder {
 	return func() Cmd {
 		g := b()
-		g.outputRules = outputRules
+		g.outputRules = maps.Clone(outputRules)
+
+		return g
+	}
+}
+
+// WithDefaultOptions sets options used by every run, e.g. "object:headerFile=boilerplate.go.txt".
+// A default option is overridden by any user option with the same marker name: "paths=./api/..." overrides the
+// default "paths=./...", and "object:year=2024" overrides the default "object:headerFile=boilerplate.go.txt".
+// Running the command without options runs the default options.
+func (b Builder) WithDefaultOptions(opts ...string) Builder {
+	return func() Cmd {
+		g := b()
+		g.defaultOptions = append(g.defaultOptions, opts...)
+
+		return g
+	}
+}
+
+// WithPreRun adds a hook invoked before the generators run, after the options are parsed from the command line.
+// The run is aborted if the hook returns an error.
+func (b Builder) WithPreRun(hook RunHook) Builder {
+	return func() Cmd {
+		g := b()
+		g.preRuns = append(g.preRuns, hook)
+
+		return g
+	}
+}
+
+// WithPostRun adds a hook invoked after all generators and finalizers ran successfully.
+func (b Builder) WithPostRun(hook RunHook) Builder {
+	return func() Cmd {
+		g := b()
+		g.postRuns = append(g.postRuns, hook)
+
+		return g
+	}
+}
+
+// WithMarkerAlias registers oldName as a deprecated alias of the generator registered as newName, so renamed
+// generators keep working. A deprecation warning is printed when the alias is used.
+func (b Builder) WithMarkerAlias(oldName, newName string) Builder {
+	return func() Cmd {
+		g := b()
+		g.markerAliases[oldName] = newName
+
+		return g
+	}
+}
+
+// WithDeprecatedMarker deprecates the marker (e.g. "yourgen:old"), registered by a generator: a warning with the given
+// message (e.g. "please use \"+yourgen:new\" instead") is printed for each use of the marker found in the source code
+// during a run. Markers can also be deprecated by their help, see markers.DefinitionHelp.DeprecatedInFavorOf.
+func (b Builder) WithDeprecatedMarker(name, message string) Builder {
+	return func() Cmd {
+		g := b()
+		g.deprecatedMarkers[strings.TrimPrefix(name, "+")] = message
+
+		return g
+	}
+}
+
+// WithMarkerCategory groups the markers registered by the generator under the given category in the help, e.g. -w,
+// overriding the categories they registered. Without it, the category of the help of the generator (see
+// genall.HasHelp) is given to the markers it registers without a category, which are otherwise not shown.
+func (b Builder) WithMarkerCategory(genName, category string) Builder {
+	return func() Cmd {
+		g := b()
+		g.markerCategories[genName] = category
+
+		return g
+	}
+}
+
+// WithoutOptionsMarkers skips the registration of the common options markers provided by genall (e.g. "paths").
+// Please note that without these markers, users can no longer select the packages to load with "paths=./...", and
+// the generators will only run against the package in the current working directory.
+func (b Builder) WithoutOptionsMarkers() Builder {
+	return func() Cmd {
+		g := b()
+		g.withoutOptionsMarkers = true
+
+		return g
+	}
+}
+
+// WithoutFormatting disables the formatting of the files written with WriteFile in every run, as if SkipFormat were
+// set, e.g. to see the raw output of the generators when a formatting error masks the actual problem. The files may
+// not compile. The formatting can also be disabled for a single run with --no-format.
+func (b Builder) WithoutFormatting() Builder {
+	return func() Cmd {
+		g := b()
+		g.noFormat = true
+
+		return g
+	}
+}
+
+// WithErrorHint customizes the hint printed by Run after an error, e.g. for embedders who nest the command or
+// renamed its flags. The function is given the command which failed, and an empty hint is not printed. By default,
+// the hint points to `<cmd> <args> -w` and `<cmd> <args> -h`, except for nested commands.
+func (b Builder) WithErrorHint(hint func(cmd *cobra.Command) string) Builder {
+	return func() Cmd {
+		g := b()
+		g.errorHint = hint
+
+		return g
+	}
+}
+
+// WithHelpSort sorts and groups the markers in the help with the given sorter, e.g. help.SortByOption or
+// help.SortByCategory, instead of the default of each help: by option in the usage, and by category in the help of the
+// markers. The --markers-sort flag overrides it at runtime.
+func (b Builder) WithHelpSort(sorter help.SortGroup) Builder {
+	return func() Cmd {
+		g := b()
+		g.helpSort = sorter
 
 		return g
 	}
 }
 
+// Extend applies the given functions to the builder, so the generators, output rules and options shared by several
+// commands can be defined once, e.g. by a company for all its generator binaries:
+//
+//	func WithCompanyDefaults(b genutils.Builder) genutils.Builder {
+//		return b.WithOutputRule("bucket", bucket.OutputRule{}).
+//			WithDefaultOptions("object:headerFile=hack/boilerplate.go.txt")
+//	}
+//
+//	genutils.New("gencmd").Extend(WithCompanyDefaults).WithGenerator("yourgen", yourgen.Generator{})
+//
+// Builders are values: a builder extended by several commands is not modified by them, as each Apply builds a new
+// command from scratch.
+func (b Builder) Extend(fns ...func(Builder) Builder) Builder {
+	for _, fn := range fns {
+		b = fn(b)
+	}
+
+	return b
+}
+
 func (b Builder) Apply() Cmd {
 	return b()
 }
 
 func register(g Cmd) { //nolint:gochecknoinits,cyclop
-	for genName, generator := range g.generators {
-		// make the generator options marker itself
-		def := markers.Must(markers.MakeDefinition(genName, markers.DescribesPackage, generator))
-		if err := g.markerRegistry.Register(def); err != nil {
-			panic(err)
+	if err := registerPlugins(g); err != nil {
+		panic(err)
+	}
+
+	// the aliases of output rules are registered like the rules themselves, pointing at the same rule
+	for alias, canonical := range g.outputRuleAliases {
+		rule, ok := g.outputRules[canonical]
+		if !ok {
+			panic(fmt.Errorf("cannot alias %q to unknown output rule %q", alias, canonical))
 		}
 
-		if helpGiver, hasHelp := generator.(genall.HasHelp); hasHelp {
-			if h := helpGiver.Help(); h != nil {
-				g.markerRegistry.AddHelp(def, h)
-			}
+		if _, exists := g.outputRules[alias]; exists {
+			panic(fmt.Errorf("cannot alias %q to output rule %q: an output rule is already registered as %q",
+				alias, canonical, alias))
 		}
 
-		// make per-generation output rule markers
-		for ruleName, rule := range g.outputRules {
-			ruleMarker := markers.Must(markers.MakeDefinition(
-				fmt.Sprintf("output:%s:%s", genName, ruleName), markers.DescribesPackage, rule))
-			if err := g.markerRegistry.Register(ruleMarker); err != nil {
-				panic(err)
-			}
+		g.outputRules[alias] = rule
+	}
 
-			if helpGiver, hasHelp := rule.(genall.HasHelp); hasHelp {
-				if h := helpGiver.Help(); h != nil {
-					g.markerRegistry.AddHelp(ruleMarker, h)
-				}
-			}
+	if _, ok := g.markerSources[".go"]; ok {
+		panic(errors.New("cannot register a marker source for \".go\" files, their markers are read by the collector"))
+	}
+
+	namespaceGenerators(g)
+
+	for genName, generator := range g.generators {
+		registerGenerator(g, genName, generator)
+	}
+
+	// make the deprecated aliases of generators
+	for oldName, newName := range g.markerAliases {
+		generator, ok := g.generators[newName]
+		if !ok {
+			panic(fmt.Errorf("cannot alias %q to unknown generator %q", oldName, newName))
+		}
+
+		def := registerGenerator(g, oldName, generator)
+
+		category := ""
+		if h := g.markerRegistry.HelpFor(def); h != nil {
+			category = h.Category
 		}
+
+		g.markerRegistry.AddHelp(def, markers.DeprecatedHelp(newName, category,
+			fmt.Sprintf("deprecated alias of %q", newName)))
 	}
 
 	// make "default output" output rule markers
 	for ruleName, rule := range g.outputRules {
-		ruleMarker := markers.Must(markers.MakeDefinition("output:"+ruleName, markers.DescribesPackage, rule))
+		ruleMarker := markers.Must(markers.MakeDefinition(outputMarkerName(g, "", ruleName), markers.DescribesPackage, rule))
 		if err := g.markerRegistry.Register(ruleMarker); err != nil {
 			panic(err)
 		}
@@ -181,127 +546,1105 @@ func register(g Cmd) { //nolint:gochecknoinits,cyclop
 	}
 
 	// add in the common options markers
+	if g.withoutOptionsMarkers {
+		return
+	}
+
 	if err := genall.RegisterOptionsMarkers(g.markerRegistry); err != nil {
 		panic(err)
 	}
 }
 
-func (c Cmd) Run() {
-	register(c)
+// registerGenerator makes the generator options marker and its per-generation output rule markers.
+func registerGenerator(g Cmd, genName string, generator genall.Generator) *markers.Definition {
+	// make the generator options marker itself
+	def := markers.Must(markers.MakeDefinition(genName, markers.DescribesPackage, generator))
+	if err := g.markerRegistry.Register(def); err != nil {
+		panic(err)
+	}
 
-	cmd := c.cmd()
+	if helpGiver, hasHelp := generator.(genall.HasHelp); hasHelp {
+		if h := helpGiver.Help(); h != nil {
+			g.markerRegistry.AddHelp(def, h)
+		}
+	}
+
+	// make per-generation output rule markers
+	for ruleName, rule := range g.outputRules {
+		ruleMarker := markers.Must(markers.MakeDefinition(
+			outputMarkerName(g, genName, ruleName), markers.DescribesPackage, rule))
+		if err := g.markerRegistry.Register(ruleMarker); err != nil {
+			panic(err)
+		}
 
-	if err := cmd.Execute(); err != nil {
-		var noUsageErr noUsageError
-		if noUsage := errors.Is(err, noUsageErr); !noUsage {
-			// print the usage unless we suppressed it
-			if err := cmd.Usage(); err != nil {
-				panic(err)
+		if helpGiver, hasHelp := rule.(genall.HasHelp); hasHelp {
+			if h := helpGiver.Help(); h != nil {
+				g.markerRegistry.AddHelp(ruleMarker, h)
 			}
 		}
+	}
 
-		_, err = fmt.Fprintf(
-			cmd.OutOrStderr(),
-			"run `%[1]s %[2]s -w` to see all available markers, or `%[1]s %[2]s -h` for usage\n",
-			cmd.CalledAs(), strings.Join(os.Args[1:], " "))
+	return def
+}
 
-		if err != nil {
-			os.Exit(1)
-		}
+// ensureRegistered registers the marker definitions if it was not already done.
+func (c Cmd) ensureRegistered() {
+	c.registerOnce.Do(func() { register(c) })
+}
 
-		os.Exit(1)
+func (c Cmd) Run() {
+	if code := c.execute(nil); code != ExitCodeSuccess {
+		os.Exit(code)
 	}
 }
 
-//nolint:funlen
-func (c Cmd) cmd() *cobra.Command {
-	helpLevel := 0
-	whichLevel := 0
-	showVersion := false
+// execute runs the command with the arguments of the command line, printing the usage and the error hint on errors,
+// and returns its exit code. The output is written to out if set, or to the standard streams.
+func (c Cmd) execute(out io.Writer) int {
+	if c.name == "" {
+		panic("genutils: the command has no name, it can only be embedded with AddTo")
+	}
 
-	cmd := &cobra.Command{ //nolint:exhaustruct,exhaustivestruct
-		Use:     c.name,
-		Short:   c.description,
-		Long:    c.description,
-		Example: c.helper,
-		RunE: func(ccmd *cobra.Command, rawOpts []string) error {
-			// print version if asked for it
-			if showVersion {
-				version.Print()
+	c.ensureRegistered()
 
-				return nil
-			}
+	cmd := c.cmd()
+	// cobra would read os.Args itself, without guarding against an empty one
+	cmd.SetArgs(commandArgs())
 
-			// print the help if we asked for it (since we've got a different help flag :-/), then bail
-			if helpLevel > 0 {
-				return ccmd.Usage()
-			}
+	if out != nil {
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+	}
 
-			// print the marker docs if we asked for them, then bail
-			if whichLevel > 0 {
-				return printMarkerDocs(c, ccmd, rawOpts, whichLevel)
-			}
+	executed, err := cmd.ExecuteC()
+	if err == nil {
+		return ExitCodeSuccess
+	}
 
-			// otherwise, set up the runtime for actually running the generators
-			runtime, err := genall.FromOptions(c.markerRegistry, rawOpts)
-			if err != nil {
-				return err
-			}
+	if printsUsage(err) {
+		if err := executed.Usage(); err != nil {
+			panic(err)
+		}
+	}
 
-			if len(runtime.Generators) == 0 {
-				return errors.New("no generators specified")
-			}
+	if hint := c.errorHint(executed); hint != "" {
+		_, _ = fmt.Fprintln(executed.OutOrStderr(), hint)
+	}
 
-			if hadErrs := runtime.Run(); hadErrs {
-				// don't obscure the actual error with a bunch of usage
-				return noUsageError{errors.New("not all generators ran successfully")}
-			}
+	return ExitCode(err)
+}
 
-			return nil
-		},
-		SilenceUsage: true, // silence the usage, then print it out ourselves if it wasn't suppressed
+// defaultErrorHint points to the flags printing the markers and the usage, unless the command is nested: it can't be
+// run with the arguments of the command line as is, and subcommands don't take markers.
+func defaultErrorHint(cmd *cobra.Command) string {
+	if cmd.HasParent() {
+		return ""
 	}
 
-	cmd.Flags().CountVarP(&whichLevel, "which-markers", "w", "print out all markers available with the requested generators\n(up to -www for the most detailed output, or -wwww for json output)") //nolint:lll
-	cmd.Flags().CountVarP(&helpLevel, "detailed-help", "h", "print out more detailed help\n(up to -hhh for the most detailed output, or -hhhh for json output)")                                   //nolint:lll
-	cmd.Flags().BoolVar(&showVersion, "version", false, "show version")
-	cmd.Flags().Bool("help", false, "print out usage and a summary of options")
-	oldUsage := cmd.UsageFunc()
-	cmd.SetUsageFunc(func(cmd *cobra.Command) error {
-		if err := oldUsage(cmd); err != nil {
-			return err
-		}
+	return fmt.Sprintf("run `%[1]s %[2]s -w` to see all available markers, or `%[1]s %[2]s -h` for usage",
+		cmd.CalledAs(), strings.Join(commandArgs(), " "))
+}
 
-		if helpLevel == 0 {
-			helpLevel = summaryHelp
-		}
+// AddTo adds the command as a subcommand of parent, named name, or the name given to New if name is empty. Errors are
+// returned by the Execute method of parent, and can be mapped to an exit code with ExitCode.
+func (c Cmd) AddTo(parent *cobra.Command, name string) {
+	if name != "" {
+		c.name = name
+	}
 
-		_, err := fmt.Fprintf(cmd.OutOrStderr(), "\n\nOptions\n\n")
-		if err != nil {
-			return err //nolint:wrapcheck
-		}
+	if c.name == "" {
+		panic("genutils: AddTo requires a name for a command built without one")
+	}
 
-		return helpForLevels(cmd.OutOrStdout(), cmd.OutOrStderr(), helpLevel, c.markerRegistry, help.SortByOption)
-	})
+	c.ensureRegistered()
+	parent.AddCommand(c.cmd())
+}
 
-	return cmd
+// commandArgs returns the arguments of the command line without the program name, or nothing if os.Args is empty,
+// e.g. when driven from tests or embedded harnesses that don't set it.
+func commandArgs() []string {
+	if len(os.Args) == 0 {
+		// not nil, so cobra does not read os.Args itself
+		return []string{}
+	}
+
+	return os.Args[1:]
 }
 
-// printMarkerDocs prints out marker help for the given generators specified in
-// the rawOptions, at the given level.
-func printMarkerDocs(g Cmd, cmd *cobra.Command, rawOptions []string, whichLevel int) error {
-	// just grab a registry, so we don't lag while trying to load roots
-	// (like we'd do if we just constructed the full runtime).
-	reg, err := genall.RegistryFromOptions(g.markerRegistry, rawOptions)
+// Generate runs the generators activated by the given options, without going through the command line.
+func (c Cmd) Generate(opts []string) error {
+	metrics := newRunMetrics(c.metrics != "", time.Now())
+
+	c.ensureRegistered()
+
+	if c.timeout > 0 {
+		ctx, cancel := context.WithTimeout(c.Context(), c.timeout)
+		defer cancel()
+
+		c.ctx = ctx
+	}
+
+	// the files given on the command line, e.g. --metrics, remain relative to the working directory
+	rootDir, err := resolveRootDir(c.rootDir)
 	if err != nil {
 		return err
 	}
 
-	return helpForLevels(cmd.OutOrStdout(), cmd.OutOrStderr(), whichLevel, reg, help.SortByCategory)
-}
+	c.rootDir = rootDir
 
-func helpForLevels(mainOut io.Writer, errOut io.Writer, whichLevel int, reg *markers.Registry, sorter help.SortGroup) error { //nolint:lll,cyclop
-	helpInfo := help.ByCategory(reg, sorter)
+	if c.deterministic {
+		if err := checkDeterminism(c, opts); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	opts, err = selectGenerators(c, withDefaultOptions(c, opts))
+	if err != nil {
+		return err
+	}
+
+	for _, preRun := range c.preRuns {
+		if err := preRun(c, opts); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	parsed, err := parseOptions(c, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(generatorNames(parsed)) == 0 {
+		return newNoGeneratorsError(c, opts)
+	}
+
+	// set up the runtime for actually running the generators
+	runtime, err := newRuntime(c, parsed)
+	if err != nil {
+		return err
+	}
+
+	if c.incremental {
+		stale := staleRoots(runtime.Roots)
+		if len(stale) == 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "all packages are up to date, nothing to generate")
+
+			return nil
+		}
+
+		if c.verbose && len(stale) < len(runtime.Roots) {
+			_, _ = fmt.Fprintf(os.Stderr, "skipping %d up-to-date packages out of %d\n",
+				len(runtime.Roots)-len(stale), len(runtime.Roots))
+		}
+
+		runtime.Roots = stale
+	}
+
+	warnDeprecatedMarkers(c, runtime, os.Stderr)
+
+	archives := startTarArchives(runtime)
+
+	// before the output rules are wrapped, so the rules writing to the standard output are still recognized
+	restoreStdout, err := redirectStdout(runtime, generatorStdout(c, runtime))
+	if err != nil {
+		return noUsageError{err}
+	}
+
+	var files map[string]*bytes.Buffer
+	if c.verify || c.diff || c.capture != nil {
+		files = bufferOutputs(runtime)
+	}
+
+	if c.verbose {
+		logOutputRules(runtime, parsed, os.Stderr)
+	}
+
+	metrics.countOutputs(runtime, generatorNames(parsed))
+
+	// the files written are also logged with --verbose, e.g. for the callers of Run
+	result := c.result
+	if result == nil && c.verbose {
+		result = &Result{} //nolint:exhaustruct
+	}
+
+	if result != nil && files == nil {
+		result.recordWrites(runtime)
+	}
+	guardOutputs(runtime, generatorNames(parsed))
+
+	// last, so WriteFile finds them on the output rule of the generation context
+	if c.noFormat || c.skipEmpty {
+		withWriteOptions(runtime, c.noFormat, c.skipEmpty)
+	}
+
+	// the markers of the other files are read once the registry of the collector is filled by the generators
+	ctx := withSourceMarkers(c.Context(), collectSourceMarkers(c, runtime))
+
+	origins := make(errorOrigins)
+	hadErrs := run(ctx, runtime, generatorNames(parsed),
+		newProgress(c.progress, os.Stderr, len(runtime.Generators), len(runtime.Roots)), origins, metrics)
+	if err := c.Context().Err(); err != nil {
+		_ = restoreStdout()
+
+		if c.timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			err = &timeoutError{timeout: c.timeout, err: err}
+		}
+
+		return noUsageError{err}
+	}
+
+	if hadFinalizeErrs := finalize(runtime, metrics); hadFinalizeErrs {
+		hadErrs = true
+	}
+
+	if err := restoreStdout(); err != nil {
+		return noUsageError{err}
+	}
+
+	if c.capture != nil {
+		c.capture.collect(files, runtime.Roots)
+	}
+
+	if !c.verify && !c.diff && c.capture == nil {
+		if err := flushTarArchives(archives); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	if c.verbose && files == nil {
+		_, _ = fmt.Fprintf(os.Stderr, "files written: %d\n", result.FilesWritten)
+	}
+
+	if c.errorReport != "" {
+		if err := writeErrorReport(c.errorReport, runtime.Roots, origins); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	if c.metrics != "" {
+		if err := writeMetrics(c.metrics, metrics); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	if c.markerDocs != "" {
+		if err := emitMarkerDocs(c, runtime, opts, c.markerDocs); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	switch {
+	case hadErrs && c.keepGoing:
+		_, _ = fmt.Fprintln(os.Stderr, "warning: not all generators ran successfully, keep going")
+	case hadErrs:
+		// don't obscure the actual error with a bunch of usage
+		return noUsageError{ErrGeneration}
+	}
+
+	// nothing was written, hence there is nothing for the post-run hooks to process
+	switch {
+	case c.verify:
+		if err := verifyOutputs(os.Stdout, files); err != nil {
+			return noUsageError{err}
+		}
+
+		return nil
+	case c.diff:
+		if err := printOutputsDiff(os.Stdout, files); err != nil {
+			return noUsageError{err}
+		}
+
+		return nil
+	case c.capture != nil:
+		return nil
+	}
+
+	for _, postRun := range c.postRuns {
+		if err := postRun(c, opts); err != nil {
+			return noUsageError{err}
+		}
+	}
+
+	return nil
+}
+
+// EnabledGenerators returns the names of the generators the given options would activate, without running anything.
+func (c Cmd) EnabledGenerators(opts []string) ([]string, error) {
+	c.ensureRegistered()
+
+	opts, err := selectGenerators(c, withDefaultOptions(c, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseOptions(c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return generatorNames(parsed), nil
+}
+
+// generatorNames returns the names of the generators activated by the options, in order.
+func generatorNames(parsed []parsedOption) []string {
+	names := make([]string, 0)
+
+	for _, opt := range parsed {
+		if _, isGenerator := opt.val.(genall.Generator); isGenerator {
+			names = append(names, opt.def.Name)
+		}
+	}
+
+	return names
+}
+
+// withDefaultOptions prepends the default options of the command which are not overridden by an option with the
+// same marker name.
+func withDefaultOptions(c Cmd, opts []string) []string {
+	if len(c.defaultOptions) == 0 {
+		return opts
+	}
+
+	overridden := make(map[string]bool, len(opts))
+	for _, opt := range opts {
+		overridden[optionName(c, opt)] = true
+	}
+
+	merged := make([]string, 0, len(c.defaultOptions)+len(opts))
+
+	for _, opt := range c.defaultOptions {
+		if !overridden[optionName(c, opt)] {
+			merged = append(merged, opt)
+		}
+	}
+
+	return append(merged, opts...)
+}
+
+// selectGenerators applies the --only and --skip flags to the options:
+//   - the options of generators not in --only, or in --skip, are removed, including their per-generator output rules.
+//   - the generators of --only which are not activated by the options are activated with their default options.
+//   - with --skip but without --only nor any generator activated by the options, all other generators are activated.
+func selectGenerators(c Cmd, opts []string) ([]string, error) { //nolint:cyclop
+	if len(c.only) == 0 && len(c.skip) == 0 {
+		return opts, nil
+	}
+
+	only, err := generatorSet(c, c.only)
+	if err != nil {
+		return nil, err
+	}
+
+	skip, err := generatorSet(c, c.skip)
+	if err != nil {
+		return nil, err
+	}
+
+	isSelected := func(genName string) bool {
+		return (len(only) == 0 || only[genName]) && !skip[genName]
+	}
+
+	selected := make([]string, 0, len(opts))
+	activated := make(map[string]bool)
+	activatedAny := false
+
+	for _, opt := range opts {
+		name := optionName(c, opt)
+
+		genName, isGenerator := generatorOf(c, name)
+		if genName == "" {
+			// not related to a generator, e.g. "paths" or a default output rule
+			selected = append(selected, opt)
+
+			continue
+		}
+
+		activatedAny = activatedAny || isGenerator
+
+		if !isSelected(genName) {
+			continue
+		}
+
+		activated[genName] = activated[genName] || isGenerator
+		selected = append(selected, opt)
+	}
+
+	candidates := make([]string, 0)
+
+	switch {
+	case len(only) > 0:
+		for _, genName := range c.only {
+			candidates = append(candidates, canonicalGeneratorName(c, genName))
+		}
+	case !activatedAny:
+		candidates = SortedKeys(c.generators)
+	}
+
+	for _, genName := range candidates {
+		if isSelected(genName) && !activated[genName] {
+			activated[genName] = true
+			selected = append(selected, genName)
+		}
+	}
+
+	return selected, nil
+}
+
+// generatorSet returns the set of the canonical names of the generators, which must be registered.
+func generatorSet(c Cmd, genNames []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(genNames))
+
+	for _, genName := range genNames {
+		canonical := canonicalGeneratorName(c, genName)
+		if _, ok := c.generators[canonical]; !ok {
+			return nil, newUnknownGeneratorError(c, genName)
+		}
+
+		set[canonical] = true
+	}
+
+	return set, nil
+}
+
+// generatorOf returns the canonical name of the generator the marker of an option belongs to, and whether it is the
+// options marker of the generator itself, rather than one of its per-generator output rules. It returns an empty name
+// for options not related to a generator.
+func generatorOf(c Cmd, markerName string) (string, bool) {
+	if genName, _, isOutput := parseOutputMarkerName(markerName); isOutput {
+		if genName == "" {
+			return "", false
+		}
+
+		return canonicalGeneratorName(c, genName), false
+	}
+
+	canonical := canonicalGeneratorName(c, markerName)
+	if _, ok := c.generators[canonical]; ok {
+		return canonical, true
+	}
+
+	return "", false
+}
+
+// canonicalGeneratorName resolves the deprecated alias of a generator to its new name.
+func canonicalGeneratorName(c Cmd, genName string) string {
+	if newName, isAlias := c.markerAliases[genName]; isAlias {
+		return newName
+	}
+
+	return genName
+}
+
+// optionName returns the name of the marker definition of the option, or the raw name of the option if it is unknown.
+func optionName(c Cmd, opt string) string {
+	raw := strings.TrimPrefix(opt, "+")
+	if def := c.markerRegistry.Lookup("+"+raw, markers.DescribesPackage); def != nil {
+		return def.Name
+	}
+
+	name, _, _ := strings.Cut(raw, "=")
+
+	return name
+}
+
+// parsedOption is an option parsed with the marker registry of the command.
+type parsedOption struct {
+	raw string
+	def *markers.Definition
+	val interface{}
+}
+
+// parseOptions parses and validates the options with the marker registry of the command, returning errors that can be
+// inspected with errors.As (see UnknownGeneratorError and OptionParseError).
+func parseOptions(c Cmd, opts []string) ([]parsedOption, error) {
+	parsed := make([]parsedOption, 0, len(opts))
+	invoked := make(map[string]bool)
+
+	for _, rawOpt := range opts {
+		raw := strings.TrimPrefix(rawOpt, "+")
+
+		def := c.markerRegistry.Lookup("+"+raw, markers.DescribesPackage)
+		if def == nil {
+			return nil, newUnknownOptionError(c, raw)
+		}
+
+		val, err := def.Parse("+" + raw)
+		if err != nil {
+			return nil, &OptionParseError{Option: raw, Err: err}
+		}
+
+		if _, isGenerator := val.(genall.Generator); isGenerator {
+			if invoked[def.Name] {
+				return nil, &OptionParseError{Option: raw, Err: fmt.Errorf("multiple instances of %q generator", def.Name)}
+			}
+
+			invoked[def.Name] = true
+		}
+
+		parsed = append(parsed, parsedOption{raw: raw, def: def, val: val})
+	}
+
+	// per-generator output rules must refer to an invoked generator
+	for _, opt := range parsed {
+		if _, isOutputRule := opt.val.(genall.OutputRule); !isOutputRule {
+			continue
+		}
+
+		if genName, _, _ := parseOutputMarkerName(opt.def.Name); genName != "" && !invoked[genName] {
+			return nil, &OptionParseError{Option: opt.raw, Err: fmt.Errorf("generator %q is not invoked", genName)}
+		}
+	}
+
+	return parsed, nil
+}
+
+// CheckGenerators invokes the RegisterMarkers method of each generator against a throwaway registry and reports,
+// with the name of the generator, any error, panic, or marker conflicting with one registered by another generator.
+func (c Cmd) CheckGenerators() error {
+	type owner struct {
+		genName string
+		def     *markers.Definition
+	}
+
+	errs := make([]error, 0)
+	owners := make(map[string]owner)
+
+	for _, genName := range SortedKeys(c.generators) {
+		generator := c.generators[genName]
+
+		if _, err := markers.MakeDefinition(genName, markers.DescribesPackage, generator); err != nil {
+			errs = append(errs, fmt.Errorf("generator %q: invalid options marker: %w", genName, err))
+		}
+
+		reg := &markers.Registry{}
+		if err := registerMarkersSafely(generator, reg); err != nil {
+			errs = append(errs, fmt.Errorf("generator %q: %w", genName, err))
+
+			continue
+		}
+
+		for _, def := range reg.AllDefinitions() {
+			key := fmt.Sprintf("%s (%s)", def.Name, def.Target)
+
+			if o, ok := owners[key]; ok && o.def != def {
+				errs = append(errs, fmt.Errorf("generator %q: marker %s conflicts with the one registered by generator %q",
+					genName, key, o.genName))
+
+				continue
+			}
+
+			owners[key] = owner{genName: genName, def: def}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// GeneratorMarkers returns the definitions of the markers registered by the named generator, sorted by name and target,
+// without running it: its RegisterMarkers method is invoked against a throwaway registry, e.g. to document the
+// markers of each generator. The options marker of the generator is not included.
+func (c Cmd) GeneratorMarkers(name string) ([]*markers.Definition, error) {
+	generator, ok := c.generators[name]
+	if !ok {
+		return nil, newUnknownGeneratorError(c, name)
+	}
+
+	reg := &markers.Registry{}
+	if err := registerMarkersSafely(generator, reg); err != nil {
+		return nil, fmt.Errorf("generator %q: %w", name, err)
+	}
+
+	defs := reg.AllDefinitions()
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Name != defs[j].Name {
+			return defs[i].Name < defs[j].Name
+		}
+
+		return defs[i].Target < defs[j].Target
+	})
+
+	return defs, nil
+}
+
+// registerMarkersSafely invokes the RegisterMarkers method of the generator, turning panics into errors.
+func registerMarkersSafely(generator genall.Generator, into *markers.Registry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while registering markers: %v", r)
+		}
+	}()
+
+	return generator.RegisterMarkers(into) //nolint:wrapcheck
+}
+
+//nolint:funlen
+func (c Cmd) cmd() *cobra.Command {
+	helpLevel := 0
+	whichLevel := 0
+	showVersion := false
+	selfCheck := false
+	listOutputRules := false
+	generatorHelp := ""
+	keepGoing := false
+	verify := false
+	diff := false
+	incremental := false
+	timeout := time.Duration(0)
+	markerDocs := ""
+	errorReport := ""
+	metrics := ""
+	verbose := false
+	progress := false
+	only := make([]string, 0)
+	skip := make([]string, 0)
+	paths := make([]string, 0)
+	exclude := make([]string, 0)
+	buildFlags := make([]string, 0)
+	rootDir := ""
+	noFormat := false
+	skipEmpty := false
+	pathsFrom := ""
+	markersSort := ""
+
+	cmd := &cobra.Command{ //nolint:exhaustruct,exhaustivestruct
+		Use:     c.name,
+		Short:   c.description,
+		Long:    c.description,
+		Example: c.helper,
+		// options are positional arguments, which cobra rejects by default for commands with subcommands
+		Args: cobra.ArbitraryArgs,
+		RunE: func(ccmd *cobra.Command, rawOpts []string) error {
+			if markersSort != "" {
+				sorter, err := parseHelpSort(markersSort)
+				if err != nil {
+					return err
+				}
+
+				c.helpSort = sorter
+			}
+
+			// print version if asked for it
+			if showVersion {
+				version.Print()
+
+				return nil
+			}
+
+			// print the help if we asked for it (since we've got a different help flag :-/), then bail
+			if helpLevel > 0 {
+				return ccmd.Usage()
+			}
+
+			// print the marker docs if we asked for them, then bail
+			if whichLevel > 0 {
+				return printMarkerDocs(c, ccmd, rawOpts, HelpLevel(whichLevel))
+			}
+
+			// print the marker docs of a single generator if we asked for them, then bail
+			if generatorHelp != "" {
+				return printGeneratorDocs(c, ccmd, generatorHelp)
+			}
+
+			// list the output rules if we asked for them, then bail
+			if listOutputRules {
+				return printOutputRules(c, ccmd.OutOrStdout())
+			}
+
+			// check the markers registered by the generators if we asked for it, then bail
+			if selfCheck {
+				if err := c.CheckGenerators(); err != nil {
+					return noUsageError{err}
+				}
+
+				_, err := fmt.Fprintln(ccmd.OutOrStdout(), "all generators registered their markers successfully")
+
+				return err //nolint:wrapcheck
+			}
+
+			warnDeprecatedAliases(c, ccmd.ErrOrStderr(), rawOpts)
+
+			// merge the paths specified as flags into the marker options
+			if pathsFrom != "" {
+				pathsFromFile, err := readPaths(ccmd.InOrStdin(), pathsFrom)
+				if err != nil {
+					return err
+				}
+
+				paths = append(paths, pathsFromFile...)
+			}
+
+			rawOpts = append(rawOpts, pathsOptions(paths)...)
+
+			// otherwise, actually run the generators
+			c.keepGoing = keepGoing
+			c.verify = verify
+			c.diff = diff
+			c.incremental = incremental
+			c.timeout = timeout
+			c.markerDocs = markerDocs
+			c.errorReport = errorReport
+			c.metrics = metrics
+			c.verbose = verbose
+			c.only = only
+			c.skip = skip
+			c.exclude = exclude
+			c.buildFlags = append(slices.Clip(c.buildFlags), buildFlags...)
+			c.rootDir = rootDir
+			c.noFormat = c.noFormat || noFormat
+			c.skipEmpty = skipEmpty
+
+			// report the progress by default on a terminal only, to keep logs clean
+			c.progress = progress
+			if !ccmd.Flags().Changed("progress") {
+				f, isFile := ccmd.ErrOrStderr().(*os.File)
+				c.progress = isFile && isTerminal(f)
+			}
+
+			return c.GenerateContext(ccmd.Context(), rawOpts)
+		},
+		SilenceUsage: true, // silence the usage, then print it out ourselves if it wasn't suppressed
+	}
+
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &UsageError{Err: err}
+	})
+
+	// don't shadow generators with the default "completion" subcommand
+	cmd.CompletionOptions.DisableDefaultCmd = true
+	cmd.AddCommand(c.lintCmd(), c.explainCmd())
+
+	cmd.Flags().CountVarP(&whichLevel, "which-markers", "w", "print out all markers available with the requested generators\n(up to -www for the most detailed output, or -wwww for json output)") //nolint:lll
+	cmd.Flags().CountVarP(&helpLevel, "detailed-help", "h", "print out more detailed help\n(up to -hhh for the most detailed output, or -hhhh for json output)")                                   //nolint:lll
+	cmd.Flags().BoolVar(&showVersion, "version", false, "show version")
+	cmd.Flags().StringVar(&pathsFrom, "paths-from", "", "read newline-separated package paths to generate for from a file\n(or from stdin with \"-\")") //nolint:lll
+	cmd.Flags().Bool("strict", true, "fail if any generator reports an error (default behavior)")
+	cmd.Flags().BoolVar(&keepGoing, "keep-going", false, "report errors from generators but exit successfully\n(best-effort generation)") //nolint:lll
+	cmd.MarkFlagsMutuallyExclusive("strict", "keep-going")
+	cmd.Flags().StringSliceVar(&only, "only", nil, "only run the given generators, activating them if needed\n(e.g. \"--only foo,bar\")")              //nolint:lll
+	cmd.Flags().StringSliceVar(&skip, "skip", nil, "do not run the given generators\n(runs all other generators if none is activated by the options)") //nolint:lll
+	cmd.Flags().BoolVar(&progress, "progress", false, "report how many generators completed out of the total\n(enabled by default on a terminal)")     //nolint:lll
+	cmd.Flags().BoolVar(&verify, "verify", false, "fail with a diff if the generated files on disk are out of date,\nwithout modifying them")          //nolint:lll
+	cmd.Flags().BoolVar(&diff, "diff", false, "print the diff between the files on disk and the generated files,\nwithout modifying them")             //nolint:lll
+	cmd.MarkFlagsMutuallyExclusive("verify", "diff")
+	cmd.Flags().BoolVar(&incremental, "incremental", false, "only generate for the packages with source files newer than their generated files\n(changes to dependencies are not detected)") //nolint:lll
+	cmd.MarkFlagsMutuallyExclusive("incremental", "verify")
+	cmd.MarkFlagsMutuallyExclusive("incremental", "diff")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "abort the run if it takes longer than the given duration (e.g. \"5m\")")
+	cmd.Flags().BoolVar(&noFormat, "no-format", false, "do not format the generated files, e.g. to debug the raw output of the generators\n(the files may not compile)")                   //nolint:lll
+	cmd.Flags().BoolVar(&skipEmpty, "skip-empty", false, "do not write the files whose content is empty, e.g. for packages without markers\n(the existing files are left untouched)")      //nolint:lll
+	cmd.Flags().BoolVar(&verbose, "verbose", false, "log the output rule selected for each file and its destination,\nthen the number of files written")                                   //nolint:lll
+	cmd.Flags().StringVar(&markerDocs, "emit-marker-docs", "", "also write the Markdown documentation of the markers used by the run\nto the given path, through the default output rule") //nolint:lll
+	cmd.Flags().StringVar(&errorReport, "error-report", "", "write the errors of the generators to the given path as a JSON array\nof {package, file, line, column, message, generator}")  //nolint:lll
+	cmd.Flags().StringVar(&metrics, "metrics", "", "write the timing and the file counts of the generators to the given path\nin the Prometheus text format")                              //nolint:lll
+	cmd.Flags().StringVar(&markersSort, "markers-sort", "", "sort the markers in the help by \"option\" or by \"category\"")
+	cmd.Flags().StringVar(&generatorHelp, "generator-help", "", "print out the detailed help of the markers of a single generator")
+	cmd.Flags().BoolVar(&listOutputRules, "list-output-rules", false, "print the name and the summary of each output rule, separated by a tab, then exit") //nolint:lll
+	cmd.Flags().BoolVar(&selfCheck, "self-check", false, "check the markers registered by each generator, then exit")
+	cmd.Flags().StringArrayVar(&paths, "paths", nil, "package paths to generate for (e.g. \"./...\"), can be repeated\n(equivalent to the \"paths=<PATH>\" option)")                      //nolint:lll
+	cmd.Flags().StringVar(&rootDir, "root-dir", "", "load the packages and resolve the relative output paths from the given directory\ninstead of the working directory")                 //nolint:lll
+	cmd.Flags().StringArrayVar(&buildFlags, "build-flags", nil, "pass the flag to the build system when loading the packages, can be repeated\n(e.g. \"-tags=integration\")")             //nolint:lll
+	cmd.Flags().StringArrayVar(&exclude, "exclude", nil, "do not generate for the packages whose import path matches the glob, can be repeated\n(e.g. \"example.com/mod/testdata/...\")") //nolint:lll
+	cmd.Flags().Bool("help", false, "print out usage and a summary of options")
+	oldUsage := cmd.UsageFunc()
+	cmd.SetUsageFunc(func(cmd *cobra.Command) error {
+		if err := oldUsage(cmd); err != nil {
+			return err
+		}
+
+		if helpLevel == 0 {
+			helpLevel = int(summaryHelp)
+		}
+
+		_, err := fmt.Fprintf(cmd.OutOrStderr(), "\n\nOptions\n\n")
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		return helpForLevels(cmd.OutOrStdout(), cmd.OutOrStderr(), HelpLevel(helpLevel), c.markerRegistry,
+			helpSorter(c, help.SortByOption))
+	})
+
+	return cmd
+}
+
+// warnDeprecatedAliases prints a warning for each option using a deprecated alias of a generator.
+func warnDeprecatedAliases(c Cmd, w io.Writer, rawOpts []string) {
+	for _, rawOpt := range rawOpts {
+		if !strings.HasPrefix(rawOpt, "+") {
+			rawOpt = "+" + rawOpt
+		}
+
+		def := c.markerRegistry.Lookup(rawOpt, markers.DescribesPackage)
+		if def == nil {
+			continue
+		}
+
+		genName := def.Name
+		if outputGenName, _, isOutput := parseOutputMarkerName(def.Name); isOutput {
+			genName = outputGenName
+		}
+
+		if newName, ok := c.markerAliases[genName]; ok {
+			_, _ = fmt.Fprintf(w, "warning: %q is deprecated, please use %q instead\n", genName, newName)
+		}
+	}
+}
+
+// finalize invokes the generators implementing Finalizer, in the order they were specified, adding the time they spend
+// to m. It returns true if any finalizer failed.
+func finalize(runtime *genall.Runtime, m *runMetrics) bool {
+	if runtime.ErrorWriter == nil {
+		runtime.ErrorWriter = os.Stderr
+	}
+
+	hadErrs := false
+
+	for i, gen := range runtime.Generators {
+		finalizer, ok := (*gen).(Finalizer)
+		if !ok {
+			continue
+		}
+
+		ctx := runtime.GenerationContext // make a shallow copy
+		ctx.OutputRule = runtime.OutputRules.ForGenerator(gen)
+
+		if _, needsChecking := (*gen).(genall.NeedsTypeChecking); !needsChecking {
+			ctx.Checker = nil
+		}
+
+		start := time.Now()
+		if err := finalizer.Finalize(&ctx); err != nil {
+			_, _ = fmt.Fprintln(runtime.ErrorWriter, err)
+			hadErrs = true
+		}
+
+		m.timed(i, start)
+	}
+
+	return hadErrs
+}
+
+// pathsOptions converts the given package paths into "paths" marker options.
+func pathsOptions(paths []string) []string {
+	opts := make([]string, 0, len(paths))
+	for _, path := range paths {
+		opts = append(opts, "paths="+path)
+	}
+
+	return opts
+}
+
+// readPaths reads newline-separated package paths from the file at path, or from stdin if path is "-".
+func readPaths(stdin io.Reader, path string) ([]string, error) {
+	r := stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	paths := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			paths = append(paths, line)
+		}
+	}
+
+	return paths, scanner.Err() //nolint:wrapcheck
+}
+
+// WriteHelp writes the help of the markers of all the generators of the command to w, at the given level, like the
+// "-w" flag does without going through the command line.
+func (c Cmd) WriteHelp(w io.Writer, level HelpLevel, sorter help.SortGroup) error {
+	c.ensureRegistered()
+
+	reg, err := markerDocsRegistry(c, SortedKeys(c.generators))
+	if err != nil {
+		return err
+	}
+
+	return helpForLevels(w, w, level, reg, sorter)
+}
+
+// helpSorter returns the sorter of the markers in the help set with WithHelpSort or --markers-sort, or the fallback,
+// ignoring the marker namespace of the command, if any.
+func helpSorter(c Cmd, fallback help.SortGroup) help.SortGroup {
+	sorter := fallback
+	if c.helpSort != nil {
+		sorter = c.helpSort
+	}
+
+	if c.markerNamespace != "" {
+		return namespacedSortGroup{SortGroup: sorter, namespace: c.markerNamespace}
+	}
+
+	return sorter
+}
+
+// parseHelpSort returns the sorter of the markers in the help with the given name, "option" or "category".
+func parseHelpSort(name string) (help.SortGroup, error) {
+	switch name {
+	case "option":
+		return help.SortByOption, nil
+	case "category":
+		return help.SortByCategory, nil
+	default:
+		return nil, &UsageError{Err: fmt.Errorf("invalid --markers-sort %q: must be \"option\" or \"category\"", name)}
+	}
+}
+
+// printMarkerDocs prints out marker help for the given generators specified in
+// the rawOptions, at the given level.
+func printMarkerDocs(g Cmd, cmd *cobra.Command, rawOptions []string, whichLevel HelpLevel) error {
+	reg, err := markerDocsRegistry(g, rawOptions)
+	if err != nil {
+		return err
+	}
+
+	errOut, closePager := cmd.OutOrStderr(), func() error { return nil }
+	if whichLevel != jsonHelp {
+		errOut, closePager = withPager(errOut)
+	}
+
+	return errors.Join(
+		helpForLevels(cmd.OutOrStdout(), errOut, whichLevel, reg, helpSorter(g, help.SortByCategory)),
+		closePager(),
+	)
+}
+
+// markerDocsRegistry returns a registry of the markers of the generators specified in the rawOptions, including the
+// plugins and simple generators, with their categories applied.
+func markerDocsRegistry(g Cmd, rawOptions []string) (*markers.Registry, error) {
+	// just grab a registry, so we don't lag while trying to load roots
+	// (like we'd do if we just constructed the full runtime).
+	reg, err := genall.RegistryFromOptions(g.markerRegistry, rawOptions)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if err := registerBoundMarkers(g, reg, rawOptions); err != nil {
+		return nil, err
+	}
+
+	if err := applyMarkerCategories(g, reg); err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// printOutputRules prints the name of each output rule of the command, including the aliases, with the summary of its
+// help, if any, separated by a tab, one per line and sorted by name.
+func printOutputRules(c Cmd, w io.Writer) error {
+	c.ensureRegistered()
+
+	buf := new(bytes.Buffer)
+
+	for _, ruleName := range SortedKeys(c.outputRules) {
+		summary := ""
+		if helpGiver, hasHelp := c.outputRules[ruleName].(genall.HasHelp); hasHelp && helpGiver.Help() != nil {
+			summary = strings.TrimSpace(helpGiver.Help().Summary)
+		}
+
+		fmt.Fprintf(buf, "%s\t%s\n", ruleName, summary)
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err //nolint:wrapcheck
+}
+
+// printGeneratorDocs prints out the detailed help of the options marker of the given generator and of the markers it
+// registers.
+func printGeneratorDocs(g Cmd, cmd *cobra.Command, genName string) error {
+	generator, ok := g.generators[genName]
+	if !ok {
+		return newUnknownGeneratorError(g, genName)
+	}
+
+	reg := &markers.Registry{}
+
+	if def := g.markerRegistry.Lookup("+"+genName, markers.DescribesPackage); def != nil {
+		if err := reg.Register(def); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if h := g.markerRegistry.HelpFor(def); h != nil {
+			reg.AddHelp(def, h)
+		}
+	}
+
+	if err := generator.RegisterMarkers(reg); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := applyMarkerCategories(g, reg); err != nil {
+		return err
+	}
+
+	errOut, closePager := withPager(cmd.OutOrStderr())
+
+	return errors.Join(
+		helpForLevels(cmd.OutOrStdout(), errOut, detailedHelp, reg, helpSorter(g, help.SortByCategory)),
+		closePager(),
+	)
+}
+
+// applyMarkerCategories sets the category of the help of the markers of the registry, as configured with
+// WithMarkerCategory or by the help of the generator registering them.
+func applyMarkerCategories(g Cmd, reg *markers.Registry) error {
+	for _, genName := range SortedKeys(g.generators) {
+		generator := g.generators[genName]
+
+		category, override := g.markerCategories[genName]
+		if !override {
+			if helpGiver, hasHelp := generator.(genall.HasHelp); hasHelp && helpGiver.Help() != nil {
+				category = helpGiver.Help().Category
+			}
+		}
+
+		if category == "" {
+			continue
+		}
+
+		genReg := &markers.Registry{}
+		if err := generator.RegisterMarkers(genReg); err != nil {
+			return fmt.Errorf("generator %q: %w", genName, err)
+		}
+
+		for _, genDef := range genReg.AllDefinitions() {
+			def := reg.Lookup("+"+genDef.Name, genDef.Target)
+			if def == nil {
+				continue
+			}
+
+			h := markers.DefinitionHelp{}
+			if existing := reg.HelpFor(def); existing != nil {
+				h = *existing
+			}
+
+			if h.Category != "" && !override {
+				continue
+			}
+
+			h.Category = category
+			reg.AddHelp(def, &h)
+		}
+	}
+
+	return nil
+}
+
+func helpForLevels(mainOut io.Writer, errOut io.Writer, whichLevel HelpLevel, reg *markers.Registry, sorter help.SortGroup) error { //nolint:lll,cyclop
+	helpInfo := help.ByCategory(reg, sorter)
 
 	switch whichLevel {
 	case jsonHelp:
@@ -321,116 +1664,921 @@ func helpForLevels(mainOut io.Writer, errOut io.Writer, whichLevel int, reg *mar
 				return err
 			}
 		}
-	case summaryHelp:
-		for _, cat := range helpInfo {
-			if cat.Category == "" {
-				continue
-			}
+	case summaryHelp:
+		for _, cat := range helpInfo {
+			if cat.Category == "" {
+				continue
+			}
+
+			contents := prettyhelp.MarkersSummary(cat.Category, cat.Markers)
+			if err := contents.WriteTo(errOut); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+	}
+
+	return nil
+}
+
+// HelpLevel is the level of detail of the help of the markers, as selected by counting the "-w" or "-h" flags.
+type HelpLevel int
+
+const (
+	_ HelpLevel = iota
+	// HelpSummary lists the markers with their summary ("-w").
+	HelpSummary
+	// HelpDetailed describes the markers and their fields ("-ww").
+	HelpDetailed
+	// HelpFull describes the markers and their fields in full ("-www").
+	HelpFull
+	// HelpJSON prints the help of the markers as JSON ("-wwww"), written to the standard output instead of the
+	// standard error when run from the command line.
+	HelpJSON
+)
+
+const (
+	summaryHelp  = HelpSummary
+	detailedHelp = HelpDetailed
+	fullHelp     = HelpFull
+	jsonHelp     = HelpJSON
+)
+
+// noUsageError suppresses usage printing when it occurs
+// (since cobra doesn't provide a good way to avoid printing
+// out usage in only certain situations).
+type noUsageError struct{ error }
+
+func (e noUsageError) Unwrap() error {
+	return e.error
+}
+
+// printsUsage reports whether the usage should be printed along with the error, unless a noUsageError suppressed it.
+func printsUsage(err error) bool {
+	var noUsageErr noUsageError
+
+	return !errors.As(err, &noUsageErr)
+}
+
+// WriteFile -----------------------------------------------------------------------------------------------------------
+
+const headerTemplate = "%[2]s\n"
+
+// LineEnding is the line ending of the files written by WriteFile.
+type LineEnding string
+
+const (
+	// LineEndingLF ends lines with "\n", as emitted by the formatter. This is the default.
+	LineEndingLF LineEnding = "\n"
+	// LineEndingCRLF ends lines with "\r\n", e.g. for repositories normalizing generated files to CRLF.
+	LineEndingCRLF LineEnding = "\r\n"
+)
+
+type WriteFileOption struct {
+	// CmdName is the name of the command line used to
+	CmdName    string
+	Filename   string
+	HeaderFile string
+	// Year substitutes the year placeholders of the header, see RenderHeader. Defaults to the current year.
+	Year string
+
+	// FilenameFunc computes the Filename from Root and TypeName if Filename is empty, e.g. for generators writing one
+	// file per type, so the naming is consistent across generators. See GeneratedFilenameFunc.
+	FilenameFunc func(root *loader.Package, typeName string) string
+	// TypeName is the name of the type the file is generated for, given to FilenameFunc.
+	TypeName string
+	// Subdir is the directory the file is written to, relative to the output of Root (or to OutputDir), e.g.
+	// "generated". It is created if missing. The output rule of Ctx must report the paths of its artifacts, see
+	// OutputPather.
+	Subdir string
+
+	// GeneratedByArgs are the invocation arguments recorded after CmdName in the "Code generated by" banner.
+	GeneratedByArgs []string
+
+	// Footer is appended verbatim after the content. Go code or comments are formatted with the rest of
+	// the file.
+	Footer string
+	// NoLintDirective injects a file-level "//nolint" directive right before the package clause, e.g. "nolint:lll" or
+	// "//nolint:errcheck,lll".
+	NoLintDirective string
+	// BuildTags is the build constraint of the file, written as a "//go:build" line at the top of the file, e.g. "linux"
+	// or "linux && amd64". See BuildVariants.
+	BuildTags string
+	// ErrorOnEmptyBody fails instead of writing a file whose content (Buffer or Content) is empty, i.e. has nothing but
+	// blank lines, comments and the package clause, which usually denotes a bug of the generator. The error is recorded
+	// on Root, or returned if Root is nil. The files with an empty content are silently skipped instead in the runs
+	// with --skip-empty, unless ErrorOnEmptyBody is set.
+	ErrorOnEmptyBody bool
+	// KeepRegions preserves the hand-written regions of the existing file, delimited by KeepStartSentinel and
+	// KeepEndSentinel, across regenerations: the content of each region of the existing file replaces the content of
+	// the region at the same position in the new output. The content of the new regions is the default.
+	KeepRegions bool
+	// SortDeclarations re-emits the top-level declarations in a canonical order (constants, variables, types, then
+	// functions, each sorted alphabetically), preserving imports and comments, to avoid noisy diffs.
+	SortDeclarations bool
+	// SkipFormat disables formatting of the output, e.g. for non-Go files. The formatting of all the files of a run is
+	// disabled with Builder.WithoutFormatting or --no-format.
+	SkipFormat bool
+	// ShouldFormat reports whether the file should be formatted, given its Filename. Defaults to FormatGoFiles.
+	ShouldFormat func(filename string) bool
+	// GroupImports rewrites the imports, after formatting, into a single declaration with canonical groups: standard
+	// library, third-party, then packages of the module of Root (or of OutputDir), each sorted by import path.
+	// Aliases and comments are preserved, and no import is added nor removed.
+	GroupImports bool
+	// MaxLineLength reports an error, with Root.AddError, for each line of the output longer than MaxLineLength
+	// characters, so generators can be fixed to comply with strict line length lint rules. Lines are not rewrapped.
+	// Disabled if zero.
+	MaxLineLength int
+	// ChecksumSidecar also writes the SHA-256 checksum of the output to "<Filename>.sha256", through the same output
+	// rule, in the format of "sha256sum" so it can be checked with "sha256sum -c". This lets build systems detect
+	// generated files edited by hand.
+	ChecksumSidecar bool
+	// LineEnding converts the line endings of the output, as a last step after formatting. Defaults to LineEndingLF.
+	LineEnding LineEnding
+
+	// DryRun assembles and formats the output without opening nor writing the file. The would-be output is written
+	// to DryRunWriter if set.
+	DryRun       bool
+	DryRunWriter io.Writer
+
+	// Buffer is the content of the file, after the header and the banner.
+	Buffer *bytes.Buffer
+	// Content is read instead of Buffer if set, so the content can be streamed from any producer, e.g. a template or
+	// another file. It is read whole before formatting.
+	Content io.Reader
+
+	// Ctx is the generation context the header file is read with, and the output file is opened with. If nil, e.g.
+	// outside a genall run, the header file is read from the filesystem, and the output file is written directly to
+	// OutputDir, or to the directory of Root.
+	Ctx  *genall.GenerationContext
+	Root *loader.Package
+
+	// OutputDir and PackageName are used when Root is nil, to write a file for a package that doesn't exist yet.
+	// In that mode, the file is written directly to OutputDir (bypassing the output rule of Ctx), the package clause
+	// is written from PackageName (the content must not contain it, unless PackageName is empty), and errors that would
+	// have been reported with Root.AddError (e.g. formatting errors) are returned instead.
+	OutputDir   string
+	PackageName string
+}
+
+func WriteFile(o WriteFileOption) (err error) { //nolint:cyclop,funlen
+	if o.Root == nil && o.OutputDir == "" {
+		return errors.New("OutputDir must be set when Root is nil")
+	}
+
+	if o.Filename == "" && o.FilenameFunc != nil {
+		o.Filename = o.FilenameFunc(o.Root, o.TypeName)
+	}
+
+	var headerText string
+
+	if o.HeaderFile != "" {
+		headerBytes, err := readHeaderFile(o)
+		if err != nil {
+			return err
+		}
+
+		headerText = string(RenderHeader(headerBytes, o.Year))
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if o.BuildTags != "" {
+		expr, err := constraint.Parse("//go:build " + o.BuildTags)
+		if err != nil {
+			return fmt.Errorf("invalid build tags %q of %q: %w", o.BuildTags, o.Filename, err)
+		}
+
+		if _, err := fmt.Fprintf(buffer, "//go:build %s\n\n", expr); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	pkgName := o.PackageName
+	if o.Root != nil {
+		pkgName = o.Root.Name
+	}
+
+	if _, err := fmt.Fprintf(buffer, headerTemplate, pkgName, headerText); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if o.CmdName != "" {
+		if _, err := fmt.Fprintf(buffer, "\n// Code generated by %s. DO NOT EDIT.\n", generatedBy(o)); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	if o.Root == nil && o.PackageName != "" {
+		if _, err := fmt.Fprintf(buffer, "\npackage %s\n", o.PackageName); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	bodyStart := buffer.Len()
+
+	switch {
+	case o.Content != nil:
+		if _, err := buffer.ReadFrom(o.Content); err != nil {
+			return fmt.Errorf("reading the content of %q: %w", o.Filename, err)
+		}
+	case o.Buffer != nil:
+		buffer.Write(o.Buffer.Bytes())
+	}
+
+	if (o.ErrorOnEmptyBody || runSkipsEmpty(o.Ctx)) && isEmptyBody(o.Filename, buffer.Bytes()[bodyStart:]) {
+		if !o.ErrorOnEmptyBody {
+			return nil
+		}
+
+		return reportError(o, fmt.Errorf("%s: the generated content is empty", o.Filename))
+	}
+
+	if o.Footer != "" {
+		writeFooter(buffer, o.Footer)
+	}
+
+	outBytes := buffer.Bytes()
+
+	if o.NoLintDirective != "" {
+		if outBytes, err = injectNoLintDirective(outBytes, o.NoLintDirective); err != nil {
+			return err
+		}
+	}
+
+	if o.KeepRegions {
+		if outBytes, err = keepExistingRegions(o, outBytes); err != nil {
+			return err
+		}
+	}
+
+	// regions delimited by FormatOffSentinel and FormatOnSentinel are preserved verbatim.
+	outBytes, regions, err := protectRegions(outBytes)
+	if err != nil {
+		return err
+	}
+
+	if o.SortDeclarations {
+		if sorted, err := sortDeclarations(outBytes); err != nil {
+			if err := reportError(o, err); err != nil {
+				return err
+			}
+		} else {
+			outBytes = sorted
+		}
+	}
+
+	if shouldFormat(o) {
+		if formatted, err := format.Source(outBytes); err != nil {
+			if err := reportError(o, err); err != nil {
+				return err
+			}
+		} else {
+			outBytes = formatted
+		}
+	}
+
+	if o.GroupImports {
+		if grouped, err := groupImports(outBytes, modulePath(o.Root, o.OutputDir)); err != nil {
+			if err := reportError(o, err); err != nil {
+				return err
+			}
+		} else {
+			outBytes = grouped
+		}
+	}
+
+	outBytes = restoreRegions(outBytes, regions)
+
+	if o.MaxLineLength > 0 {
+		if err := checkLineLength(o.Filename, outBytes, o.MaxLineLength); err != nil {
+			if err := reportError(o, err); err != nil {
+				return err
+			}
+		}
+	}
 
-			contents := prettyhelp.MarkersSummary(cat.Category, cat.Markers)
-			if err := contents.WriteTo(errOut); err != nil {
-				return err //nolint:wrapcheck
-			}
+	if o.LineEnding != "" && o.LineEnding != LineEndingLF {
+		outBytes = convertLineEndings(outBytes, o.LineEnding)
+	}
+
+	if o.DryRun {
+		if o.DryRunWriter == nil {
+			return nil
+		}
+
+		_, err := o.DryRunWriter.Write(outBytes)
+
+		return err //nolint:wrapcheck
+	}
+
+	if err := writeOutputFile(o, outBytes); err != nil {
+		return err
+	}
+
+	if !o.ChecksumSidecar {
+		return nil
+	}
+
+	sidecar := o
+	sidecar.Filename = o.Filename + ".sha256"
+
+	return writeOutputFile(sidecar, []byte(fmt.Sprintf("%x  %s\n", sha256.Sum256(outBytes), filepath.Base(o.Filename))))
+}
+
+// writeOutputFile writes the content to the output file of o.
+func writeOutputFile(o WriteFileOption, content []byte) (err error) {
+	outputFile, err := openOutputFile(o)
+	if err != nil {
+		return err
+	}
+
+	defer func(outputFile io.WriteCloser) {
+		closeErr := outputFile.Close()
+
+		if closeErr != nil {
+			err = errors.Join(err, reportError(o, closeErr))
 		}
+	}(outputFile)
+
+	n, err := outputFile.Write(content)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if n < len(content) {
+		return io.ErrShortWrite
 	}
 
 	return nil
 }
 
-const (
-	_ = iota
-	summaryHelp
-	detailedHelp
-	fullHelp
-	jsonHelp
-)
+// FormatGoFiles reports whether the file is a Go file, i.e. if its name ends with ".go".
+func FormatGoFiles(filename string) bool {
+	return strings.HasSuffix(filename, ".go")
+}
 
-// noUsageError suppresses usage printing when it occurs
-// (since cobra doesn't provide a good way to avoid printing
-// out usage in only certain situations).
-type noUsageError struct{ error }
+// shouldFormat reports whether the output of WriteFile should be formatted.
+func shouldFormat(o WriteFileOption) bool {
+	if o.SkipFormat || runSkipsFormatting(o.Ctx) {
+		return false
+	}
 
-// WriteFile -----------------------------------------------------------------------------------------------------------
+	if o.ShouldFormat == nil {
+		return FormatGoFiles(o.Filename)
+	}
 
-const headerTemplate = "%[2]s\n"
+	return o.ShouldFormat(o.Filename)
+}
 
-type WriteFileOption struct {
-	// CmdName is the name of the command line used to
-	CmdName    string
-	Filename   string
-	HeaderFile string
+// WriteTemplate executes the template with the given data and writes the result with WriteFile.
+// Template execution errors are returned as a *TemplateError.
+func WriteTemplate(o WriteFileOption, tmpl *template.Template, data interface{}) error {
+	buffer := new(bytes.Buffer)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		return &TemplateError{Name: tmpl.Name(), Err: err}
+	}
 
-	Buffer *bytes.Buffer
-	Ctx    *genall.GenerationContext
-	Root   *loader.Package
+	o.Buffer = buffer
+
+	return WriteFile(o)
 }
 
-func WriteFile(o WriteFileOption) error {
-	var headerText string
+// reportError records the error on the Root, or returns it if Root is nil.
+func reportError(o WriteFileOption, err error) error {
+	if o.Root == nil {
+		return err
+	}
 
-	if o.HeaderFile != "" {
-		headerBytes, err := o.Ctx.ReadFile(o.HeaderFile)
-		if err != nil {
-			return err
+	o.Root.AddError(err)
+
+	return nil
+}
+
+// readHeaderFile reads the header file with the generation context, or from the filesystem if Ctx is nil. Other files
+// than regular ones are accepted, e.g. "/dev/null" for an empty header, but directories are not.
+func readHeaderFile(o WriteFileOption) ([]byte, error) {
+	var (
+		header []byte
+		err    error
+	)
+
+	if o.Ctx == nil {
+		if info, err := os.Stat(o.HeaderFile); err == nil && info.IsDir() {
+			return nil, fmt.Errorf("header file path %q is a directory, expected a file", o.HeaderFile)
 		}
 
-		headerText = string(headerBytes)
+		header, err = os.ReadFile(o.HeaderFile)
+	} else {
+		// the input rule opens directories, reading them fails
+		if header, err = o.Ctx.ReadFile(o.HeaderFile); errors.Is(err, syscall.EISDIR) {
+			return nil, fmt.Errorf("header file path %q is a directory, expected a file", o.HeaderFile)
+		}
 	}
 
-	buffer := new(bytes.Buffer)
+	if err != nil {
+		return nil, fmt.Errorf("reading the header file %q: %w", o.HeaderFile, err)
+	}
+
+	return header, nil
+}
+
+// openOutputFile opens the output file with the output rule of the generation context, or directly in OutputDir if
+// Root or Ctx is nil (defaulting to the directory of Root).
+func openOutputFile(o WriteFileOption) (io.WriteCloser, error) {
+	if o.Subdir != "" && !filepath.IsLocal(o.Subdir) {
+		return nil, fmt.Errorf("invalid Subdir %q: must be a relative path inside the output directory", o.Subdir)
+	}
+
+	if o.Root != nil && o.Ctx != nil {
+		itemPath := filepath.Join(o.Subdir, o.Filename)
+
+		// the rules which cannot tell where they write may not create the subdirectory
+		if o.Subdir != "" {
+			if _, err := OutputPath(o.Ctx.OutputRule, o.Root, itemPath); err != nil {
+				return nil, fmt.Errorf("cannot write %q to the subdirectory %q: %w", o.Filename, o.Subdir, err)
+			}
+		}
+
+		return o.Ctx.Open(o.Root, itemPath) //nolint:wrapcheck
+	}
 
-	_, err := fmt.Fprintf(buffer, headerTemplate, o.Root.Name, headerText)
+	dir, err := outputDir(o)
 	if err != nil {
-		return err //nolint:wrapcheck
+		return nil, err
 	}
 
-	if o.CmdName != "" {
-		if _, err := fmt.Fprintf(buffer, "\n// Code generated by %s. DO NOT EDIT.\n", o.CmdName); err != nil {
-			return err //nolint:wrapcheck
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return os.Create(filepath.Join(dir, o.Filename)) //nolint:wrapcheck
+}
+
+// outputDir returns the directory the output file is written to when it is written directly, without the output
+// rule of Ctx: OutputDir, or the directory of Root, joined with Subdir.
+func outputDir(o WriteFileOption) (string, error) {
+	dir := o.OutputDir
+	if dir == "" {
+		if len(o.Root.CompiledGoFiles) == 0 {
+			return "", errors.New("cannot output to a package with no path on disk")
 		}
+
+		dir = filepath.Dir(o.Root.CompiledGoFiles[0])
+	}
+
+	return filepath.Join(dir, o.Subdir), nil
+}
+
+// generatedBy returns the command name followed by the invocation arguments, on a single line so the banner is
+// still recognized by the Go tooling.
+func generatedBy(o WriteFileOption) string {
+	if len(o.GeneratedByArgs) == 0 {
+		return o.CmdName
 	}
 
-	buffer.Write(o.Buffer.Bytes())
+	args := strings.Join(append([]string{o.CmdName}, o.GeneratedByArgs...), " ")
 
-	outBytes := buffer.Bytes()
-	if formatted, err := format.Source(outBytes); err != nil {
-		o.Root.AddError(err)
-	} else {
-		outBytes = formatted
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(args)
+}
+
+var noLintDirectiveRegexp = regexp.MustCompile(`^nolint(:[\w-]+(,[\w-]+)*)?$`)
+
+// injectNoLintDirective inserts the nolint directive on its own line right before the package clause, where
+// golangci-lint applies it to the whole file.
+func injectNoLintDirective(src []byte, directive string) ([]byte, error) {
+	directive = strings.TrimPrefix(strings.TrimSpace(directive), "//")
+	if !strings.HasPrefix(directive, "nolint") {
+		directive = "nolint:" + directive
 	}
 
-	outputFile, err := o.Ctx.Open(o.Root, o.Filename)
+	if !noLintDirectiveRegexp.MatchString(directive) {
+		return nil, fmt.Errorf("invalid nolint directive %q", "//"+directive)
+	}
+
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly)
 	if err != nil {
-		return err //nolint:wrapcheck
+		return nil, fmt.Errorf("cannot locate the package clause to inject the nolint directive: %w", err)
 	}
 
-	defer func(outputFile io.WriteCloser) {
-		err := outputFile.Close()
-		if err != nil {
-			o.Root.AddError(err)
+	offset := fset.Position(f.Package).Offset
+
+	out := make([]byte, 0, len(src)+len(directive)+3)
+	out = append(out, src[:offset]...)
+	out = append(out, "//"+directive+"\n"...)
+	out = append(out, src[offset:]...)
+
+	return out, nil
+}
+
+// checkLineLength returns an error naming each line longer than maxLength characters.
+func checkLineLength(filename string, src []byte, maxLength int) error {
+	errs := make([]error, 0)
+
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		if length := utf8.RuneCount(line); length > maxLength {
+			errs = append(errs, fmt.Errorf("%s:%d: line is %d characters long, exceeding the maximum of %d",
+				filename, i+1, length, maxLength))
 		}
-	}(outputFile)
+	}
+
+	return errors.Join(errs...)
+}
+
+// convertLineEndings replaces the line endings of src, LF or CRLF, with the given one.
+func convertLineEndings(src []byte, lineEnding LineEnding) []byte {
+	lf := bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+
+	return bytes.ReplaceAll(lf, []byte("\n"), []byte(lineEnding))
+}
+
+// yearPlaceholders are the placeholders substituted with the year by RenderHeader.
+var yearPlaceholders = []string{"{{.Year}}", "%Y%"}
+
+// RenderHeader substitutes the "{{.Year}}" and "%Y%" placeholders of a boilerplate header with the given year, or with
+// the current year if empty, e.g. for the "Copyright {{.Year}} The Authors." line of a license header.
+func RenderHeader(headerBytes []byte, year string) []byte {
+	if year == "" {
+		year = strconv.Itoa(time.Now().Year())
+	}
+
+	for _, placeholder := range yearPlaceholders {
+		headerBytes = bytes.ReplaceAll(headerBytes, []byte(placeholder), []byte(year))
+	}
+
+	return headerBytes
+}
+
+// isEmptyBody reports whether the content has nothing but blank lines or, for Go files, nothing but comments and the
+// package clause.
+func isEmptyBody(filename string, body []byte) bool {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+
+	if !FormatGoFiles(filename) {
+		return false
+	}
+
+	var s scanner.Scanner
+
+	// comments are skipped
+	s.Init(token.NewFileSet().AddFile(filename, -1, len(body)), body, nil, 0)
+
+	for inPackageClause := false; ; {
+		_, tok, _ := s.Scan()
+
+		switch {
+		case tok == token.EOF:
+			return true
+		case tok == token.PACKAGE:
+			inPackageClause = true
+		case tok == token.IDENT && inPackageClause:
+			inPackageClause = false
+		case tok == token.SEMICOLON:
+		default:
+			return false
+		}
+	}
+}
+
+// writeFooter appends the footer to the buffer, making sure it starts and ends on its own line.
+func writeFooter(buffer *bytes.Buffer, footer string) {
+	if buffer.Len() > 0 && !bytes.HasSuffix(buffer.Bytes(), []byte("\n")) {
+		buffer.WriteString("\n")
+	}
+
+	buffer.WriteString(footer)
+
+	if !strings.HasSuffix(footer, "\n") {
+		buffer.WriteString("\n")
+	}
+}
+
+// Output Rules --------------------------------------------------------------------------------------------------------
+
+// OutputToModuleRoot outputs each artifact relative to the root of the Go module containing the package that
+// triggered the generation (or containing the current working directory for artifacts not associated to a package).
+//
+// Generally useful for generators producing a single aggregate file for the whole module.
+var OutputToModuleRoot = outputToModuleRoot{}
 
-	n, err := outputFile.Write(outBytes)
+// outputToModuleRoot outputs each artifact relative to the root of the Go module.
+type outputToModuleRoot struct{}
+
+func (outputToModuleRoot) Help() *markers.DefinitionHelp {
+	return markers.SimpleHelp("", "outputs each artifact relative to the root of the Go module.")
+}
+
+func (o outputToModuleRoot) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	path, err := o.OutputPath(pkg, itemPath)
 	if err != nil {
-		return err //nolint:wrapcheck
+		return nil, err
 	}
 
-	if n < len(outBytes) {
-		return io.ErrShortWrite
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err //nolint:wrapcheck
 	}
 
-	return nil
+	return os.Create(path) //nolint:wrapcheck
+}
+
+func (outputToModuleRoot) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	root, err := ModuleRoot(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(root, itemPath), nil
+}
+
+// ModuleRoot returns the root directory of the Go module containing the given package. If pkg is nil, the module
+// containing the current working directory is used instead.
+func ModuleRoot(pkg *loader.Package) (string, error) {
+	if pkg != nil && pkg.Module != nil && pkg.Module.Dir != "" {
+		return pkg.Module.Dir, nil
+	}
+
+	dir := "."
+	if pkg != nil && len(pkg.CompiledGoFiles) > 0 {
+		dir = filepath.Dir(pkg.CompiledGoFiles[0])
+	}
+
+	return findModuleRoot(dir)
+}
+
+// ResolveImportPath loads the package at the given path (e.g. "./api/v1") with the given build flags, if any, and
+// returns its canonical import path. It returns an error if the path does not resolve to exactly one package, or if the
+// package cannot be loaded, e.g. if the directory contains no Go files or files that cannot be parsed.
+func ResolveImportPath(path string, buildFlags ...string) (string, error) {
+	roots, err := loader.LoadRootsWithConfig(loaderConfig(buildFlags), path)
+	if err != nil {
+		return "", &LoadError{Err: err}
+	}
+
+	switch len(roots) {
+	case 0:
+		return "", fmt.Errorf("no package found at %q", path)
+	case 1:
+	default:
+		pkgPaths := make([]string, 0, len(roots))
+		for _, root := range roots {
+			pkgPaths = append(pkgPaths, root.PkgPath)
+		}
+
+		return "", fmt.Errorf("expected exactly one package at %q, found %d: %s",
+			path, len(roots), strings.Join(pkgPaths, ", "))
+	}
+
+	if len(roots[0].Errors) > 0 {
+		errs := make([]error, 0, len(roots[0].Errors))
+		for _, err := range roots[0].Errors {
+			errs = append(errs, err)
+		}
+
+		return "", fmt.Errorf("cannot load the package at %q: %w", path, errors.Join(errs...))
+	}
+
+	return roots[0].PkgPath, nil
+}
+
+// findModuleRoot returns the closest directory containing a go.mod file, starting from dir and walking up.
+func findModuleRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err //nolint:wrapcheck
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("cannot find a go.mod file in any parent directory")
+		}
+
+		dir = parent
+	}
 }
 
 // Other Utils  --------------------------------------------------------------------------------------------------------
 
+// DefaultInitialisms are the words written in all caps by Title, PascalCase and CamelCase, e.g. "ID" or "HTTP".
+// Keys are uppercase.
+//
+// It is process-global: configure it once, e.g. with SetInitialisms in an init function, before generating anything.
+// It must not be modified while other goroutines use the casing helpers.
+var DefaultInitialisms = map[string]bool{ //nolint:gochecknoglobals
+	"ACL": true, "API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true, "EOF": true, "GUID": true,
+	"HTML": true, "HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true, "LHS": true, "QPS": true,
+	"RAM": true, "RHS": true, "RPC": true, "SLA": true, "SMTP": true, "SQL": true, "SSH": true, "TCP": true,
+	"TLS": true, "TTL": true, "UDP": true, "UI": true, "UID": true, "UUID": true, "URI": true, "URL": true,
+	"UTF8": true, "VM": true, "XML": true, "XMPP": true, "XSRF": true, "XSS": true,
+}
+
+// SetInitialisms replaces DefaultInitialisms with the given words. See DefaultInitialisms for the concurrency caveats.
+func SetInitialisms(initialisms ...string) {
+	DefaultInitialisms = make(map[string]bool, len(initialisms))
+	for _, initialism := range initialisms {
+		DefaultInitialisms[strings.ToUpper(initialism)] = true
+	}
+}
+
+// Title upper-cases the first letter of s, or the whole of s if it is one of DefaultInitialisms: "object" gives
+// "Object" and "api" gives "API".
 func Title(s string) string {
+	if s == "" {
+		return s
+	}
+
+	if DefaultInitialisms[strings.ToUpper(s)] {
+		return strings.ToUpper(s)
+	}
+
 	r := []rune(s)
 
 	return string(append([]rune{unicode.ToUpper(r[0])}, r[1:]...))
 }
 
+// PascalCase joins the words of a camel-cased, snake-cased or kebab-cased identifier, each with its first letter
+// upper-cased, and DefaultInitialisms in all caps: "http_server_id" gives "HTTPServerID".
+func PascalCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		words[i] = Title(strings.ToLower(word))
+	}
+
+	return strings.Join(words, "")
+}
+
+// CamelCase is like PascalCase, except the first word is lower-cased: "HTTP_server_id" gives "httpServerID".
+func CamelCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word)
+
+			continue
+		}
+
+		words[i] = Title(strings.ToLower(word))
+	}
+
+	return strings.Join(words, "")
+}
+
+// ReceiverName returns an idiomatic short receiver name for the given type name, made of the lowercased initials of
+// each word: "Container" gives "c", "ContainerGenerator" gives "cg" and "*pkg.HTTPServer" gives "hs".
+// It falls back to the first initial if the initials form a Go keyword.
+func ReceiverName(typeName string) string {
+	typeName = strings.TrimLeft(typeName, "*")
+	if i := strings.LastIndex(typeName, "."); i >= 0 {
+		typeName = typeName[i+1:]
+	}
+
+	initials := make([]rune, 0)
+	for _, word := range splitWords(typeName) {
+		initials = append(initials, unicode.ToLower([]rune(word)[0]))
+	}
+
+	if len(initials) == 0 {
+		return "x"
+	}
+
+	if name := string(initials); !token.IsKeyword(name) {
+		return name
+	}
+
+	return string(initials[0])
+}
+
+// GoStringLit returns s as a Go string literal, e.g. for generators writing strings into the generated code.
+// It uses the raw form `...` when s spans multiple lines or contains quotes or backslashes, and the raw form can
+// represent s, i.e. s is valid UTF-8 with only printable runes, newlines and tabs, and neither backticks nor carriage
+// returns. Otherwise it uses the double-quoted form with escapes, e.g. for backticks and non-printable runes.
+func GoStringLit(s string) string {
+	if strings.ContainsAny(s, "\n\"\\") && canBackquote(s) {
+		return "`" + s + "`"
+	}
+
+	return strconv.Quote(s)
+}
+
+// canBackquote reports whether s can be written as a raw string literal without changing its value. Unlike
+// strconv.CanBackquote it allows newlines, and it rejects carriage returns, which are discarded from raw string
+// literals.
+func canBackquote(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+
+	for _, r := range s {
+		switch {
+		case r == '`', r == '\r':
+			return false
+		case r == '\n', r == '\t':
+		case !unicode.IsPrint(r):
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitWords splits a camel-cased, snake-cased or kebab-cased identifier into words, keeping initialisms together
+// (e.g. "HTTPServer" gives "HTTP" and "Server").
+func splitWords(s string) []string {
+	words := make([]string, 0)
+	r := []rune(s)
+	start := 0
+
+	for i := 1; i <= len(r); i++ {
+		switch {
+		case i == len(r):
+		case isWordSeparator(r[i]):
+		case unicode.IsUpper(r[i]) && !unicode.IsUpper(r[i-1]) && !isWordSeparator(r[i-1]):
+		case unicode.IsUpper(r[i]) && unicode.IsUpper(r[i-1]) && i+1 < len(r) && unicode.IsLower(r[i+1]):
+		default:
+			continue
+		}
+
+		if word := strings.TrimFunc(string(r[start:i]), isWordSeparator); word != "" {
+			words = append(words, word)
+		}
+
+		start = i
+	}
+
+	return words
+}
+
+// isWordSeparator reports whether the rune separates the words of an identifier.
+func isWordSeparator(r rune) bool {
+	return r == '_' || r == '-' || r == ' '
+}
+
 func GeneratedFilename(prefix, name string) string {
 	return fmt.Sprintf("zz_generated.%s.%s.go", prefix, name)
 }
+
+// BuildVariants returns a copy of o for each build constraint, e.g. "linux" or "linux && amd64", with the Filename
+// GeneratedFilename(prefix, name) and the BuildTags set to the constraint, so each variant of a file is only built
+// under its constraint. The name of a variant is made of the tags of its constraint, e.g. "linux_amd64". The content
+// of each variant is set by the caller before writing it with WriteFile:
+//
+//	for _, variant := range genutils.BuildVariants(o, "yourgen", "linux", "darwin") {
+//		variant.Buffer = render(variant.BuildTags)
+//		if err := genutils.WriteFile(variant); err != nil {
+//			return err
+//		}
+//	}
+func BuildVariants(o WriteFileOption, prefix string, constraints ...string) []WriteFileOption {
+	variants := make([]WriteFileOption, 0, len(constraints))
+
+	for _, buildTags := range constraints {
+		variant := o
+		variant.Filename = GeneratedFilename(prefix, buildVariantName(buildTags))
+		variant.BuildTags = buildTags
+		variant.FilenameFunc = nil
+
+		variants = append(variants, variant)
+	}
+
+	return variants
+}
+
+// buildVariantName returns the name of the variant built under the constraint, made of its tags joined with "_", e.g.
+// "linux_amd64" for "linux && amd64", or "not_windows" for "!windows".
+func buildVariantName(buildTags string) string {
+	words := strings.FieldsFunc(strings.ReplaceAll(buildTags, "!", " not "), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '_'
+	})
+
+	return strings.Join(words, "_")
+}
+
+// GeneratedFilenameFunc returns a WriteFileOption.FilenameFunc naming the file of each type after GeneratedFilename,
+// e.g. "zz_generated.<prefix>.foobar.go" for the type "FooBar".
+func GeneratedFilenameFunc(prefix string) func(root *loader.Package, typeName string) string {
+	return func(_ *loader.Package, typeName string) string {
+		return GeneratedFilename(prefix, strings.ToLower(typeName))
+	}
+}
+
+// SortedKeys returns the keys of the map in ascending order, e.g. for generators to iterate over a map in a stable
+// order, as the output must not depend on the random iteration order of maps. See Builder.WithDeterministic.
+func SortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,445 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+)
+
+func TestPrintsUsage(t *testing.T) {
+	errTest := errors.New("test")
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "plain error", err: errTest, want: true},
+		{name: "usage error", err: &UsageError{Err: errTest}, want: true},
+		{name: "noUsageError", err: noUsageError{errTest}, want: false},
+		{name: "wrapped noUsageError", err: fmt.Errorf("running: %w", noUsageError{errTest}), want: false},
+		{name: "joined noUsageError", err: errors.Join(errTest, noUsageError{errTest}), want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := printsUsage(tc.err); got != tc.want {
+				t.Errorf("printsUsage(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileDryRun(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		writer *bytes.Buffer
+	}{
+		{name: "with writer", writer: new(bytes.Buffer)},
+		{name: "without writer"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			o := WriteFileOption{
+				Filename:    "zz_generated.go",
+				OutputDir:   dir,
+				PackageName: "foo",
+				Buffer:      bytes.NewBufferString("var x = 1\n"),
+				DryRun:      true,
+			}
+			if tc.writer != nil {
+				o.DryRunWriter = tc.writer
+			}
+
+			if err := WriteFile(o); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, o.Filename)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected no file to be created, got %v", err)
+			}
+
+			if tc.writer != nil && !strings.Contains(tc.writer.String(), "var x = 1") {
+				t.Errorf("expected the would-be output to be written, got %q", tc.writer.String())
+			}
+		})
+	}
+}
+
+func TestGeneratedFilename(t *testing.T) {
+	if got, want := GeneratedFilename("deepcopy", "foobar"), "zz_generated.deepcopy.foobar.go"; got != want {
+		t.Errorf("GeneratedFilename() = %q, want %q", got, want)
+	}
+
+	if got, want := GeneratedFilenameFunc("deepcopy")(nil, "FooBar"), "zz_generated.deepcopy.foobar.go"; got != want {
+		t.Errorf("GeneratedFilenameFunc() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteFileFormatsGoFiles(t *testing.T) {
+	for _, tc := range []struct {
+		filename    string
+		packageName string
+		content     string
+		want        string
+	}{
+		// Go files are formatted
+		{filename: "zz_generated.go", packageName: "foo", content: "var  x=1\n", want: "var x = 1\n"},
+		// other files are written verbatim, even if they are not valid Go code
+		{filename: "config.yaml", content: "key:   value\n", want: "key:   value\n"},
+		{filename: "notes.txt", content: "var  x=1\n", want: "var  x=1\n"},
+	} {
+		t.Run(tc.filename, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := WriteFile(WriteFileOption{
+				Filename:    tc.filename,
+				OutputDir:   dir,
+				PackageName: tc.packageName,
+				Buffer:      bytes.NewBufferString(tc.content),
+			})
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			out, err := os.ReadFile(filepath.Join(dir, tc.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasSuffix(string(out), tc.want) {
+				t.Errorf("expected the output to end with %q, got %q", tc.want, out)
+			}
+		})
+	}
+}
+
+func TestRenderHeader(t *testing.T) {
+	currentYear := strconv.Itoa(time.Now().Year())
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		year   string
+		want   string
+	}{
+		{name: "template placeholder", header: "// Copyright {{.Year}}.", year: "2042", want: "// Copyright 2042."},
+		{name: "percent placeholder", header: "// Copyright %Y%.", year: "2042", want: "// Copyright 2042."},
+		{name: "both placeholders", header: "// {{.Year}} %Y% {{.Year}}", year: "2042", want: "// 2042 2042 2042"},
+		{name: "no placeholder", header: "// Copyright 2023.", year: "2042", want: "// Copyright 2023."},
+		{name: "default year", header: "// Copyright {{.Year}}.", want: "// Copyright " + currentYear + "."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(RenderHeader([]byte(tc.header), tc.year)); got != tc.want {
+				t.Errorf("RenderHeader(%q, %q) = %q, want %q", tc.header, tc.year, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileHeaderYear(t *testing.T) {
+	dir := t.TempDir()
+
+	headerFile := filepath.Join(dir, "boilerplate.go.txt")
+	if err := os.WriteFile(headerFile, []byte("// Copyright {{.Year}} The Authors.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+
+	err := WriteFile(WriteFileOption{
+		Filename:     "zz_generated.go",
+		HeaderFile:   headerFile,
+		Year:         "2042",
+		OutputDir:    dir,
+		PackageName:  "foo",
+		DryRun:       true,
+		DryRunWriter: out,
+	})
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "// Copyright 2042 The Authors.\n") {
+		t.Errorf("expected the header with the year, got %q", out.String())
+	}
+}
+
+func TestWriteFileLineEnding(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		lineEnding LineEnding
+	}{
+		{name: "default", lineEnding: ""},
+		{name: "LF", lineEnding: LineEndingLF},
+		{name: "CRLF", lineEnding: LineEndingCRLF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := WriteFile(WriteFileOption{
+				Filename:    "zz_generated.go",
+				OutputDir:   dir,
+				PackageName: "foo",
+				// unformatted, with mixed line endings
+				Buffer:     bytes.NewBufferString("var  x=1\r\n\nvar  y=2\n"),
+				LineEnding: tc.lineEnding,
+			})
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			out, err := os.ReadFile(filepath.Join(dir, "zz_generated.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the output is formatted, then its line endings are converted
+			want := "package foo\n\nvar x = 1\n\nvar y = 2\n"
+			if tc.lineEnding == LineEndingCRLF {
+				want = strings.ReplaceAll(want, "\n", "\r\n")
+			}
+
+			if !strings.HasSuffix(string(out), want) {
+				t.Errorf("expected the output to end with %q, got %q", want, out)
+			}
+
+			if tc.lineEnding != LineEndingCRLF && bytes.Contains(out, []byte("\r\n")) {
+				t.Errorf("expected no CRLF in the output, got %q", out)
+			}
+		})
+	}
+}
+
+func TestBuilderDerivation(t *testing.T) {
+	gen := NewSimpleGenerator(nil, func(*genall.GenerationContext) error { return nil })
+
+	base := New("test").
+		WithGenerator("object", gen).
+		WithMarkerAlias("obj", "object")
+
+	first := base.
+		WithGenerator("rbac", gen).
+		WithOutputRule("custom", genall.OutputToDirectory("custom"))
+	second := base.WithGenerator("crd", gen)
+
+	// mutate the maps of a command built by the first derived builder
+	c := first()
+	c.generators["webhook"] = gen
+	c.outputRules["other"] = genall.OutputToStdout
+	c.markerAliases["wh"] = "webhook"
+
+	other := second()
+
+	if got, want := SortedKeys(other.generators), []string{"crd", "object"}; !slices.Equal(got, want) {
+		t.Errorf("expected the generators %q, got %q", want, got)
+	}
+
+	if got, want := SortedKeys(other.outputRules), []string{"dir", "module", "stdout"}; !slices.Equal(got, want) {
+		t.Errorf("expected the output rules %q, got %q", want, got)
+	}
+
+	if got, want := SortedKeys(other.markerAliases), []string{"obj"}; !slices.Equal(got, want) {
+		t.Errorf("expected the marker aliases %q, got %q", want, got)
+	}
+
+	// the builders still build the same commands
+	if got, want := SortedKeys(base().generators), []string{"object"}; !slices.Equal(got, want) {
+		t.Errorf("expected the generators %q of the base builder, got %q", want, got)
+	}
+
+	if got, want := SortedKeys(first().generators), []string{"object", "rbac"}; !slices.Equal(got, want) {
+		t.Errorf("expected the generators %q of the first derived builder, got %q", want, got)
+	}
+}
+
+func TestBuildVariants(t *testing.T) {
+	dir := t.TempDir()
+
+	o := WriteFileOption{OutputDir: dir, PackageName: "foo"}
+
+	variants := BuildVariants(o, "platform", "linux && amd64", "!windows")
+	if len(variants) != 2 {
+		t.Fatalf("expected 2 variants, got %d", len(variants))
+	}
+
+	for _, variant := range variants {
+		variant.Buffer = bytes.NewBufferString(fmt.Sprintf("const platform = %q\n", variant.BuildTags))
+
+		if err := WriteFile(variant); err != nil {
+			t.Fatalf("WriteFile(%q): %v", variant.Filename, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		filename   string
+		constraint string
+	}{
+		{filename: "zz_generated.platform.linux_amd64.go", constraint: "//go:build linux && amd64\n"},
+		{filename: "zz_generated.platform.not_windows.go", constraint: "//go:build !windows\n"},
+	} {
+		out, err := os.ReadFile(filepath.Join(dir, tc.filename))
+		if err != nil {
+			t.Fatalf("expected the variant %q to be written: %v", tc.filename, err)
+		}
+
+		if !strings.HasPrefix(string(out), tc.constraint) {
+			t.Errorf("expected %q to start with the build constraint %q, got %q", tc.filename, tc.constraint, out)
+		}
+	}
+}
+
+func TestReadHeaderFile(t *testing.T) {
+	dir := t.TempDir()
+
+	headerFile := filepath.Join(dir, "boilerplate.go.txt")
+	if err := os.WriteFile(headerFile, []byte("// header\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// generators read the header file with their generation context, resolving the relative paths from --root-dir
+	ctx := &genall.GenerationContext{InputRule: rootedInputRule{InputRule: genall.InputFromFileSystem, rootDir: dir}}
+
+	for _, tc := range []struct {
+		name       string
+		headerFile string
+		ctx        *genall.GenerationContext
+		wantErr    string
+		wantIs     error
+	}{
+		{name: "file", headerFile: headerFile},
+		{name: "directory", headerFile: dir, wantErr: "is a directory, expected a file"},
+		{
+			name:       "missing file",
+			headerFile: filepath.Join(dir, "missing.txt"),
+			wantErr:    "reading the header file",
+			wantIs:     os.ErrNotExist,
+		},
+		{name: "file with ctx", headerFile: "boilerplate.go.txt", ctx: ctx},
+		{name: "directory with ctx", headerFile: ".", ctx: ctx, wantErr: "is a directory, expected a file"},
+		{
+			name:       "missing file with ctx",
+			headerFile: "missing.txt",
+			ctx:        ctx,
+			wantErr:    "reading the header file",
+			wantIs:     os.ErrNotExist,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header, err := readHeaderFile(WriteFileOption{HeaderFile: tc.headerFile, Ctx: tc.ctx})
+
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("readHeaderFile: %v", err)
+				}
+
+				if string(header) != "// header\n" {
+					t.Errorf("expected the content of the header file, got %q", header)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tc.wantErr, err)
+			}
+
+			if tc.wantIs != nil && !errors.Is(err, tc.wantIs) {
+				t.Errorf("expected the error to match %v, got %v", tc.wantIs, err)
+			}
+		})
+	}
+}
+
+func TestGoStringLit(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		s         string
+		backquote bool
+	}{
+		{name: "empty", s: ""},
+		{name: "plain", s: "hello"},
+		{name: "newlines", s: "line 1\nline 2\n", backquote: true},
+		{name: "quotes", s: `say "hello"`, backquote: true},
+		{name: "backslashes", s: `C:\path\to`, backquote: true},
+		{name: "tabs and newlines", s: "key:\n\tvalue", backquote: true},
+		{name: "backtick", s: "use `code`\n"},
+		{name: "carriage return", s: "line 1\r\nline 2"},
+		{name: "non-printable rune", s: "bell\a\n"},
+		{name: "byte order mark", s: "\ufeff\"text\""},
+		{name: "invalid UTF-8", s: "\xff\n"},
+		{name: "unicode", s: "héllo \"wörld\" 🌍", backquote: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lit := GoStringLit(tc.s)
+
+			if got := strings.HasPrefix(lit, "`"); got != tc.backquote {
+				t.Errorf("GoStringLit(%q) = %s, expected a backquoted literal: %v", tc.s, lit, tc.backquote)
+			}
+
+			got, err := strconv.Unquote(lit)
+			if err != nil {
+				t.Fatalf("GoStringLit(%q) = %s is not a valid literal: %v", tc.s, lit, err)
+			}
+
+			if got != tc.s {
+				t.Errorf("GoStringLit(%q) = %s, unquoted to %q", tc.s, lit, got)
+			}
+		})
+	}
+}
+
+func TestRunWithoutCommandLineArgs(t *testing.T) {
+	args := os.Args
+	t.Cleanup(func() { os.Args = args })
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{name: "nil", args: nil},
+		{name: "program name only", args: []string{"prog"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Args = tc.args
+
+			gen := NewSimpleGenerator(nil, func(*genall.GenerationContext) error { return nil })
+			out := new(bytes.Buffer)
+
+			// without options, no generator is activated: the command fails, printing the usage and the error hint
+			code := New("test").WithGenerator("object", gen)().execute(out)
+
+			if code != ExitCodeUsage {
+				t.Errorf("expected the exit code %d, got %d: %s", ExitCodeUsage, code, out)
+			}
+
+			if !strings.Contains(out.String(), "to see all available markers") {
+				t.Errorf("expected the error hint in the output, got %q", out)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ go 1.21.5
 require (
 	github.com/dave/jennifer v1.7.0
 	github.com/spf13/cobra v1.8.0
+	golang.org/x/tools v0.12.0
 	sigs.k8s.io/controller-tools v0.13.0
 )
 
@@ -16,7 +17,6 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/sys v0.11.0 // indirect
-	golang.org/x/tools v0.12.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	k8s.io/apimachinery v0.28.0 // indirect
 )
@@ -0,0 +1,201 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+const (
+	stdlibImportGroup = iota
+	thirdPartyImportGroup
+	localImportGroup
+)
+
+// importChunk is the source of an import spec, including the comments preceding it.
+type importChunk struct {
+	group int
+	path  string
+	src   []byte
+}
+
+// groupImports rewrites the imports of the given Go source into a single import declaration with canonical groups:
+// standard library, third-party, then packages of the module with the given path. Each group is sorted by import path.
+// Aliases and comments are preserved, and no import is added nor removed.
+func groupImports(src []byte, modulePath string) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	importDecls := make([]*ast.GenDecl, 0)
+
+	for _, decl := range f.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			importDecls = append(importDecls, genDecl)
+		}
+	}
+
+	if len(f.Imports) == 0 {
+		return src, nil
+	}
+
+	// the preamble of cgo must stay right before `import "C"`.
+	for _, importSpec := range f.Imports {
+		if importSpec.Path.Value == `"C"` {
+			return src, nil
+		}
+	}
+
+	chunks := make([]importChunk, 0, len(f.Imports))
+	trailing := make([][]byte, 0)
+
+	for _, decl := range importDecls {
+		var start int
+		if decl.Lparen.IsValid() {
+			start = endOfLine(src, offset(decl.Lparen))
+		} else {
+			start = offset(decl.Specs[0].Pos())
+		}
+
+		for _, spec := range decl.Specs {
+			importSpec, _ := spec.(*ast.ImportSpec)
+			end := endOfLine(src, offset(spec.End()))
+			path, _ := strconv.Unquote(importSpec.Path.Value)
+
+			chunks = append(chunks, importChunk{
+				group: importGroup(path, modulePath),
+				path:  path,
+				src:   bytes.TrimSpace(src[start:end]),
+			})
+
+			start = end
+		}
+
+		// comments after the last spec of the declaration
+		if decl.Rparen.IsValid() && start < offset(decl.Rparen) {
+			if comment := bytes.TrimSpace(src[start:offset(decl.Rparen)]); len(comment) > 0 {
+				trailing = append(trailing, comment)
+			}
+		}
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if chunks[i].group != chunks[j].group {
+			return chunks[i].group < chunks[j].group
+		}
+
+		return chunks[i].path < chunks[j].path
+	})
+
+	out := bytes.NewBuffer(make([]byte, 0, len(src)))
+	out.Write(src[:offset(importDecls[0].Pos())])
+	out.WriteString("import (\n")
+
+	for i, chunk := range chunks {
+		if i > 0 && chunk.group != chunks[i-1].group {
+			out.WriteString("\n")
+		}
+
+		writeIndented(out, chunk.src)
+	}
+
+	for _, comment := range trailing {
+		writeIndented(out, comment)
+	}
+
+	out.WriteString(")")
+	out.Write(src[offset(importDecls[len(importDecls)-1].End()):])
+
+	return format.Source(out.Bytes()) //nolint:wrapcheck
+}
+
+// importGroup returns the group of the import path.
+func importGroup(path, modulePath string) int {
+	switch {
+	case modulePath != "" && (path == modulePath || strings.HasPrefix(path, modulePath+"/")):
+		return localImportGroup
+	case !strings.Contains(strings.Split(path, "/")[0], "."):
+		return stdlibImportGroup
+	default:
+		return thirdPartyImportGroup
+	}
+}
+
+// writeIndented writes each line of src indented with a tab.
+func writeIndented(out *bytes.Buffer, src []byte) {
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		out.WriteString("\t")
+		out.Write(bytes.TrimSpace(line))
+		out.WriteString("\n")
+	}
+}
+
+// modulePath returns the path of the module containing the package, or containing dir if pkg is nil. It returns an
+// empty string if the module cannot be found.
+func modulePath(pkg *loader.Package, dir string) string {
+	if pkg != nil && pkg.Module != nil && pkg.Module.Path != "" {
+		return pkg.Module.Path
+	}
+
+	var (
+		root string
+		err  error
+	)
+
+	if pkg != nil {
+		root, err = ModuleRoot(pkg)
+	} else {
+		root, err = findModuleRoot(dir)
+	}
+
+	if err != nil {
+		return ""
+	}
+
+	f, err := os.Open(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(path), `"`)
+		}
+	}
+
+	return ""
+}
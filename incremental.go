@@ -0,0 +1,77 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"time"
+
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// staleRoots returns the roots which may be out of date, see isFresh.
+func staleRoots(roots []*loader.Package) []*loader.Package {
+	stale := make([]*loader.Package, 0, len(roots))
+
+	for _, root := range roots {
+		if !isFresh(root) {
+			stale = append(stale, root)
+		}
+	}
+
+	return stale
+}
+
+// isFresh reports whether all the source files of the package are older than its generated files, i.e. the files
+// with a "Code generated ... DO NOT EDIT." comment. It returns false if it can't be determined, e.g. if the package has
+// no generated file yet.
+//
+// Only the files of the package are compared: changes to its dependencies, or to files the generators read such as a
+// header file, are not detected.
+func isFresh(root *loader.Package) bool {
+	var newestSource, oldestGenerated time.Time
+
+	hasGenerated := false
+	fset := token.NewFileSet()
+
+	for _, filename := range root.CompiledGoFiles {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return false
+		}
+
+		file, err := parser.ParseFile(fset, filename, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return false
+		}
+
+		switch {
+		case !ast.IsGenerated(file):
+			if info.ModTime().After(newestSource) {
+				newestSource = info.ModTime()
+			}
+		case !hasGenerated || info.ModTime().Before(oldestGenerated):
+			hasGenerated = true
+			oldestGenerated = info.ModTime()
+		}
+	}
+
+	return hasGenerated && newestSource.Before(oldestGenerated)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// KeepStartSentinel starts a hand-written region of a generated file, preserved across regenerations by WriteFile
+	// with KeepRegions. The region ends with KeepEndSentinel. Both sentinels must be on their own line, and are kept in
+	// the output.
+	KeepStartSentinel = "// +genutils:keep:start"
+	// KeepEndSentinel ends a region started by KeepStartSentinel.
+	KeepEndSentinel = "// +genutils:keep:end"
+)
+
+// keepExistingRegions splices the kept regions of the existing output file into src. The regions of src are checked
+// even if the file does not exist yet.
+func keepExistingRegions(o WriteFileOption, src []byte) ([]byte, error) {
+	path, err := outputFilePath(o)
+	if err != nil {
+		return nil, fmt.Errorf("cannot keep the regions of %q: %w", o.Filename, err)
+	}
+
+	var existing []byte
+
+	if path != "" {
+		existing, err = os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err //nolint:wrapcheck
+		}
+	}
+
+	out, err := spliceKeptRegions(src, bytes.ReplaceAll(existing, []byte("\r\n"), []byte("\n")))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.Filename, err)
+	}
+
+	return out, nil
+}
+
+// outputFilePath returns the path the output file is written to, or an empty string if it is not written to the
+// filesystem.
+func outputFilePath(o WriteFileOption) (string, error) {
+	if o.Root != nil && o.Ctx != nil {
+		return OutputPath(o.Ctx.OutputRule, o.Root, filepath.Join(o.Subdir, o.Filename))
+	}
+
+	dir, err := outputDir(o)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, o.Filename), nil
+}
+
+// spliceKeptRegions replaces the content of each region of src with the content of the region at the same position in
+// existing. It fails if the sentinels of either are unbalanced, or if existing has more regions than src, as their
+// content would be lost.
+func spliceKeptRegions(src, existing []byte) ([]byte, error) {
+	regions, err := keptRegions(src)
+	if err != nil {
+		return nil, err
+	}
+
+	kept, err := keptRegions(existing)
+	if err != nil {
+		return nil, fmt.Errorf("existing file: %w", err)
+	}
+
+	if len(kept) > len(regions) {
+		return nil, fmt.Errorf("the existing file has %d kept regions but the new content only %d: "+
+			"their content would be lost", len(kept), len(regions))
+	}
+
+	out := new(bytes.Buffer)
+	last := 0
+
+	for i, region := range kept {
+		out.Write(src[last:regions[i][0]])
+		out.Write(existing[region[0]:region[1]])
+
+		last = regions[i][1]
+	}
+
+	out.Write(src[last:])
+
+	return out.Bytes(), nil
+}
+
+// keptRegions returns the start and end offsets of the content of each region delimited by the keep sentinels,
+// sentinels excluded.
+func keptRegions(src []byte) ([][2]int, error) {
+	return markedRegions(src, KeepStartSentinel, KeepEndSentinel)
+}
+
+// markedRegions returns the start and end offsets of the content of each region delimited by the lines startMarker
+// and endMarker, markers excluded. It fails if the markers are nested or unbalanced.
+func markedRegions(src []byte, startMarker, endMarker string) ([][2]int, error) {
+	regions := make([][2]int, 0)
+	start, offset := -1, 0
+
+	for i, line := range bytes.SplitAfter(src, []byte("\n")) {
+		offset += len(line)
+
+		switch string(bytes.TrimSpace(line)) {
+		case startMarker:
+			if start >= 0 {
+				return nil, fmt.Errorf("line %d: nested %q", i+1, startMarker)
+			}
+
+			start = offset
+		case endMarker:
+			if start < 0 {
+				return nil, fmt.Errorf("line %d: %q without a preceding %q", i+1, endMarker, startMarker)
+			}
+
+			regions = append(regions, [2]int{start, offset - len(line)})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		return nil, fmt.Errorf("%q without a following %q", startMarker, endMarker)
+	}
+
+	return regions, nil
+}
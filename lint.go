@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// Issue is a marker found in the source code which is not registered by any generator.
+type Issue struct {
+	// Pos is the position of the marker comment.
+	Pos token.Position
+	// Marker is the marker as written in the source code, without the "+".
+	Marker string
+	// Suggestion is the name of the closest registered marker, if any is close enough.
+	Suggestion string
+}
+
+func (i Issue) String() string {
+	if i.Suggestion != "" {
+		return fmt.Sprintf("%s: unknown marker %q, did you mean %q?", i.Pos, i.Marker, i.Suggestion)
+	}
+
+	return fmt.Sprintf("%s: unknown marker %q", i.Pos, i.Marker)
+}
+
+// Lint loads the packages at the given paths (e.g. "./..."), and reports the markers found in their source code which
+// are not registered by any generator of the command, e.g. misspelled markers silently ignored by the generators.
+//
+// Markers of other tools are not reported: a marker is only checked if its prefix (the part before the first colon)
+// is, or is close to, the prefix of a registered marker.
+func (c Cmd) Lint(paths []string) ([]Issue, error) {
+	c.ensureRegistered()
+
+	reg := &markers.Registry{}
+	for _, genName := range SortedKeys(c.generators) {
+		if err := c.generators[genName].RegisterMarkers(reg); err != nil {
+			return nil, fmt.Errorf("generator %q: %w", genName, err)
+		}
+	}
+
+	known := make([]string, 0)
+	prefixes := make(map[string]bool)
+
+	for _, def := range reg.AllDefinitions() {
+		known = append(known, def.Name)
+		prefixes[strings.Split(def.Name, markerNameSeparator)[0]] = true
+	}
+
+	roots, err := loader.LoadRootsWithConfig(loaderConfig(c.buildFlags), paths...)
+	if err != nil {
+		return nil, &LoadError{Err: err}
+	}
+
+	// the loader only sets the file set of the packages when type-checking them, which linting does not need
+	fset := token.NewFileSet()
+	issues := make([]Issue, 0)
+
+	for _, root := range roots {
+		for _, filename := range root.CompiledGoFiles {
+			file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+			if err != nil {
+				return nil, &LoadError{Err: err}
+			}
+
+			for _, group := range file.Comments {
+				for _, comment := range group.List {
+					marker, isMarker := markerOfComment(comment.Text)
+					if !isMarker || isKnownMarker(reg, marker) {
+						continue
+					}
+
+					name, _, _ := strings.Cut(marker, "=")
+
+					prefix := strings.Split(name, markerNameSeparator)[0]
+					if !prefixes[prefix] && closest(prefix, SortedKeys(prefixes)) == "" {
+						continue
+					}
+
+					issues = append(issues, Issue{
+						Pos:        fset.Position(comment.Pos()),
+						Marker:     marker,
+						Suggestion: closest(name, known),
+					})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Pos.Filename != issues[j].Pos.Filename {
+			return issues[i].Pos.Filename < issues[j].Pos.Filename
+		}
+
+		return issues[i].Pos.Line < issues[j].Pos.Line
+	})
+
+	return issues, nil
+}
+
+// markerOfComment returns the marker of a "// +marker" comment, without the "+", like the markers collector does.
+func markerOfComment(text string) (string, bool) {
+	if !strings.HasPrefix(text, "//") {
+		return "", false
+	}
+
+	stripped := strings.TrimSpace(text[2:])
+	if !strings.HasPrefix(stripped, "+") {
+		return "", false
+	}
+
+	return stripped[1:], true
+}
+
+// isKnownMarker reports whether the marker is registered, for any target.
+func isKnownMarker(reg *markers.Registry, marker string) bool {
+	for _, target := range []markers.TargetType{markers.DescribesPackage, markers.DescribesType, markers.DescribesField} {
+		if reg.Lookup("+"+marker, target) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lintCmd returns the "lint" subcommand, reporting the unknown markers found in the given paths.
+func (c Cmd) lintCmd() *cobra.Command {
+	return &cobra.Command{ //nolint:exhaustruct,exhaustivestruct
+		Use:   "lint [paths...]",
+		Short: "report the markers found in the source code which are not registered by any generator",
+		Long: "report the markers found in the source code which are not registered by any generator, e.g. misspelled " +
+			"markers silently ignored by the generators (paths default to \"./...\")",
+		RunE: func(ccmd *cobra.Command, paths []string) error {
+			if len(paths) == 0 {
+				paths = []string{"./..."}
+			}
+
+			issues, err := c.Lint(paths)
+			if err != nil {
+				return err
+			}
+
+			for _, issue := range issues {
+				issue.Pos.Filename = displayPath(issue.Pos.Filename)
+				_, _ = fmt.Fprintln(ccmd.OutOrStdout(), issue)
+			}
+
+			if len(issues) > 0 {
+				return noUsageError{ErrUnknownMarkers}
+			}
+
+			return nil
+		},
+		SilenceUsage: true,
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/genall/help"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// emitMarkerDocs writes the Markdown documentation of the markers used by the run to the given path, through the
+// default output rule of the runtime, like the artifacts of the generators.
+func emitMarkerDocs(c Cmd, runtime *genall.Runtime, rawOpts []string, path string) error {
+	reg, err := markerDocsRegistry(c, rawOpts)
+	if err != nil {
+		return err
+	}
+
+	out, err := runtime.OutputRules.Default.Open(nil, path)
+	if err != nil {
+		return fmt.Errorf("emitting marker docs to %q: %w", path, err)
+	}
+
+	return errors.Join(writeMarkerDocs(out, reg), out.Close())
+}
+
+// writeMarkerDocs writes the Markdown documentation of the markers of the registry, by category.
+func writeMarkerDocs(w io.Writer, reg *markers.Registry) error {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString("# Markers\n")
+
+	for _, cat := range help.ByCategory(reg, help.SortByCategory) {
+		if cat.Category == "" {
+			continue
+		}
+
+		fmt.Fprintf(buf, "\n## %s\n", cat.Category)
+
+		for _, marker := range cat.Markers {
+			writeMarkerDoc(buf, marker)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err //nolint:wrapcheck
+}
+
+// writeMarkerDoc writes the Markdown documentation of a single marker.
+func writeMarkerDoc(buf *bytes.Buffer, marker help.MarkerDoc) {
+	fmt.Fprintf(buf, "\n### `+%s`\n\n", marker.Name)
+	fmt.Fprintf(buf, "Applies to: %s\n", marker.Target)
+
+	if marker.DeprecatedInFavorOf != nil {
+		fmt.Fprintf(buf, "\n**Deprecated**: use `+%s` instead.\n", *marker.DeprecatedInFavorOf)
+	}
+
+	if marker.Summary != "" {
+		fmt.Fprintf(buf, "\n%s\n", marker.Summary)
+	}
+
+	if marker.Details != "" {
+		fmt.Fprintf(buf, "\n%s\n", marker.Details)
+	}
+
+	if len(marker.Fields) == 0 {
+		return
+	}
+
+	buf.WriteString("\n| Field | Type | Optional | Description |\n|-------|------|----------|-------------|\n")
+
+	for _, field := range marker.Fields {
+		name := "`" + field.Name + "`"
+		if marker.AnonymousField() {
+			name = "(value)"
+		}
+
+		optional := "no"
+		if field.Optional {
+			optional = "yes"
+		}
+
+		fmt.Fprintf(buf, "| %s | `%s` | %s | %s |\n",
+			name, field.TypeString(), optional, markdownCell(field.Summary))
+	}
+}
+
+// markdownCell escapes the text to fit in a single cell of a Markdown table.
+func markdownCell(text string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(text), " "), "|", `\|`)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"reflect"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+)
+
+// MarkerKind is what a marker of the options of the command controls.
+type MarkerKind string
+
+const (
+	// MarkerKindGenerator is the options marker of a generator, e.g. "yourgen", including the deprecated aliases.
+	MarkerKindGenerator MarkerKind = "generator"
+	// MarkerKindOutputRule is the marker of an output rule, e.g. "output:dir" or "output:yourgen:dir".
+	MarkerKindOutputRule MarkerKind = "output-rule"
+	// MarkerKindOption is a common options marker, e.g. "paths".
+	MarkerKindOption MarkerKind = "option"
+)
+
+var ( //nolint:gochecknoglobals
+	generatorType  = reflect.TypeOf((*genall.Generator)(nil)).Elem()
+	outputRuleType = reflect.TypeOf((*genall.OutputRule)(nil)).Elem()
+)
+
+// MarkerIndex returns the kind of each marker the options of the command can use, by name without the leading "+",
+// e.g. for tools to tell the markers of the generators apart from the ones of the output rules. The markers the
+// generators read from the source code are not included, see GeneratorMarkers.
+func (c Cmd) MarkerIndex() map[string]MarkerKind {
+	c.ensureRegistered()
+
+	index := make(map[string]MarkerKind)
+
+	for _, def := range c.markerRegistry.AllDefinitions() {
+		switch {
+		case def.Output.Implements(generatorType):
+			index[def.Name] = MarkerKindGenerator
+		case def.Output.Implements(outputRuleType):
+			index[def.Name] = MarkerKindOutputRule
+		default:
+			index[def.Name] = MarkerKindOption
+		}
+	}
+
+	return index
+}
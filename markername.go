@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	markerNameSeparator = ":"
+	outputMarkerPrefix  = "output"
+)
+
+// markerNamePartRegexp matches a part of a marker name, i.e. what is found between colons.
+var markerNamePartRegexp = regexp.MustCompile(`^[A-Za-z][\w-]*$`)
+
+// MarkerName joins the parts of a marker name, e.g. MarkerName("mycmd", "object") gives "mycmd:object".
+// It panics if the result is not a valid marker name, see ValidateMarkerName.
+func MarkerName(parts ...string) string {
+	name := strings.Join(parts, markerNameSeparator)
+	if err := ValidateMarkerName(name); err != nil {
+		panic(err)
+	}
+
+	return name
+}
+
+// OutputMarkerName returns the name of the option marker selecting the output rule of a generator, e.g.
+// OutputMarkerName("object", "dir") gives "output:object:dir", or of the default output rule if genName is empty,
+// e.g. OutputMarkerName("", "dir") gives "output:dir".
+// It panics if the result is not a valid marker name, see ValidateMarkerName.
+func OutputMarkerName(genName, ruleName string) string {
+	if genName == "" {
+		return MarkerName(outputMarkerPrefix, ruleName)
+	}
+
+	return MarkerName(outputMarkerPrefix, genName, ruleName)
+}
+
+// ValidateMarkerName returns an error if the name is not a valid marker name: parts separated by colons, each
+// starting with a letter, followed by letters, digits, underscores or dashes.
+func ValidateMarkerName(name string) error {
+	for _, part := range strings.Split(name, markerNameSeparator) {
+		if !markerNamePartRegexp.MatchString(part) {
+			return fmt.Errorf("invalid marker name %q: invalid part %q, expected %s", name, part, markerNamePartRegexp)
+		}
+	}
+
+	return nil
+}
+
+// parseOutputMarkerName returns the names of the generator and of the output rule of an output rule marker built
+// with OutputMarkerName, possibly namespaced (see Builder.WithMarkerNamespace), in which case the name of the
+// generator is namespaced too. genName is empty for default output rules, and isOutput is false for other markers.
+func parseOutputMarkerName(name string) (genName, ruleName string, isOutput bool) {
+	parts := strings.Split(name, markerNameSeparator)
+
+	namespace := ""
+	if len(parts) > 2 && parts[1] == outputMarkerPrefix { //nolint:gomnd
+		namespace, parts = parts[0], parts[1:]
+	}
+
+	if parts[0] != outputMarkerPrefix || len(parts) < 2 { //nolint:gomnd
+		return "", "", false
+	}
+
+	ruleName = parts[len(parts)-1]
+	if len(parts) == 2 { //nolint:gomnd
+		return "", ruleName, true
+	}
+
+	genName = strings.Join(parts[1:len(parts)-1], markerNameSeparator)
+	if namespace != "" {
+		genName = namespace + markerNameSeparator + genName
+	}
+
+	return genName, ruleName, true
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+type (
+	// Marker is a marker found by a MarkerParser in a file which is not Go source code.
+	Marker struct {
+		// Text is the marker as written in the file, e.g. "+yourgen:name=foo". The leading "+" is optional.
+		Text string
+		// Line is the line of the marker in the file, starting at 1, used in the error messages. Optional.
+		Line int
+	}
+
+	// MarkerParser finds the markers in the content of a file, e.g. YAMLCommentMarkers.
+	MarkerParser func(content []byte) ([]Marker, error)
+
+	// SourceMarker is a marker found in a file of a package which is not Go source code, with the value parsed by the
+	// definition registered by the generators. See Builder.WithMarkerSource.
+	SourceMarker struct {
+		// Filename is the path of the file the marker was found in.
+		Filename string
+		// Line is the line of the marker in the file, or zero if unknown.
+		Line int
+		// Name is the name of the definition of the marker, e.g. "yourgen:name".
+		Name string
+		// Value is the value of the marker, like the values of markers.MarkerValues.
+		Value interface{}
+	}
+
+	// sourceMarkers are the markers found in the files which are not Go source code, by package.
+	sourceMarkers map[*loader.Package][]SourceMarker
+
+	// sourceMarkersKey is the key of the sourceMarkers in the context.Context of a run.
+	sourceMarkersKey struct{}
+)
+
+// WithMarkerSource reads markers from the files with the given extension (e.g. ".yaml") in the directory of each
+// package, with the parser. Like the markers read from the Go source code, the markers must be registered by a
+// generator, and the unknown markers are ignored. The generators implementing ContextGenerator receive them with
+// Context.SourceMarkers.
+//
+// Marker sources cannot be registered for ".go" files.
+func (b Builder) WithMarkerSource(ext string, parser func([]byte) ([]Marker, error)) Builder {
+	return func() Cmd {
+		g := b()
+
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		g.markerSources[ext] = parser
+
+		return g
+	}
+}
+
+// SourceMarkers returns the markers found in the files of the package which are not Go source code, in the order of
+// the files and of the markers in each file. See Builder.WithMarkerSource.
+func (c *Context) SourceMarkers(root *loader.Package) []SourceMarker {
+	found, _ := c.Value(sourceMarkersKey{}).(sourceMarkers)
+
+	return found[root]
+}
+
+// YAMLCommentMarkers is a MarkerParser reading the markers from the comments of YAML files, one per comment line,
+// e.g. "# +yourgen:name=foo". Only comments on their own line are read.
+func YAMLCommentMarkers(content []byte) ([]Marker, error) {
+	found := make([]Marker, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for line := 1; scanner.Scan(); line++ {
+		comment, isComment := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "#")
+		if !isComment {
+			continue
+		}
+
+		if text := strings.TrimSpace(comment); strings.HasPrefix(text, "+") {
+			found = append(found, Marker{Text: text, Line: line})
+		}
+	}
+
+	return found, scanner.Err() //nolint:wrapcheck
+}
+
+// collectSourceMarkers reads the markers of the files of the roots with the marker sources of the command, parsing them
+// with the registry of the collector of the runtime. Errors reading or parsing a file are added to its package.
+func collectSourceMarkers(c Cmd, runtime *genall.Runtime) sourceMarkers {
+	found := make(sourceMarkers)
+
+	if len(c.markerSources) == 0 || runtime.Collector == nil {
+		return found
+	}
+
+	for _, root := range runtime.Roots {
+		if len(root.CompiledGoFiles) == 0 {
+			continue
+		}
+
+		dir := filepath.Dir(root.CompiledGoFiles[0])
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			root.AddError(err)
+
+			continue
+		}
+
+		for _, entry := range entries {
+			parser, ok := c.markerSources[filepath.Ext(entry.Name())]
+			if !ok || entry.IsDir() {
+				continue
+			}
+
+			filename := filepath.Join(dir, entry.Name())
+
+			fileMarkers, err := readSourceMarkers(runtime.Collector.Registry, filename, parser)
+			if err != nil {
+				root.AddError(err)
+			}
+
+			found[root] = append(found[root], fileMarkers...)
+		}
+	}
+
+	return found
+}
+
+// readSourceMarkers parses the markers of the file found by the parser, skipping the unknown markers.
+func readSourceMarkers(reg *markers.Registry, filename string, parser MarkerParser) ([]SourceMarker, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	parsed, err := parser(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", displayPath(filename), err)
+	}
+
+	found := make([]SourceMarker, 0, len(parsed))
+
+	for _, marker := range parsed {
+		text := "+" + strings.TrimPrefix(marker.Text, "+")
+
+		def := lookupSourceMarker(reg, text)
+		if def == nil {
+			continue
+		}
+
+		value, err := def.Parse(text)
+		if err != nil {
+			pos := displayPath(filename)
+			if marker.Line > 0 {
+				pos = fmt.Sprintf("%s:%d", pos, marker.Line)
+			}
+
+			return found, fmt.Errorf("%s: %w", pos, err)
+		}
+
+		found = append(found, SourceMarker{Filename: filename, Line: marker.Line, Name: def.Name, Value: value})
+	}
+
+	return found, nil
+}
+
+// lookupSourceMarker returns the definition of the marker whatever its target, as the markers found outside of the Go
+// source code do not describe a package, a type or a field.
+func lookupSourceMarker(reg *markers.Registry, text string) *markers.Definition {
+	for _, target := range []markers.TargetType{markers.DescribesPackage, markers.DescribesType, markers.DescribesField} {
+		if def := reg.Lookup(text, target); def != nil {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// withSourceMarkers returns a copy of ctx carrying the markers, for Context.SourceMarkers.
+func withSourceMarkers(ctx context.Context, found sourceMarkers) context.Context {
+	return context.WithValue(ctx, sourceMarkersKey{}, found)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// runMetrics records the timing and the files of a run, as written by --metrics. A nil *runMetrics records nothing.
+type runMetrics struct {
+	start      time.Time
+	packages   int
+	generators []*generatorMetrics
+}
+
+// generatorMetrics are the metrics of a single generator of the run.
+type generatorMetrics struct {
+	name     string
+	duration time.Duration
+	files    int
+}
+
+// newRunMetrics returns a runMetrics recording a run started at start, or nil if the metrics are disabled.
+func newRunMetrics(enabled bool, start time.Time) *runMetrics {
+	if !enabled {
+		return nil
+	}
+
+	return &runMetrics{start: start} //nolint:exhaustruct
+}
+
+// countOutputs wraps the output rule of each generator of the runtime, so the files each generator writes are counted,
+// including those written when finalizing it.
+func (m *runMetrics) countOutputs(runtime *genall.Runtime, genNames []string) {
+	if m == nil {
+		return
+	}
+
+	m.packages = len(runtime.Roots)
+
+	for i, gen := range runtime.Generators {
+		genMetrics := &generatorMetrics{name: genNames[i]} //nolint:exhaustruct
+		m.generators = append(m.generators, genMetrics)
+
+		runtime.OutputRules.ByGenerator[gen] = countedOutputRule{
+			OutputRule: runtime.OutputRules.ForGenerator(gen),
+			files:      &genMetrics.files,
+		}
+	}
+}
+
+// timed adds the time since start to the duration of the i-th generator of the run.
+func (m *runMetrics) timed(i int, start time.Time) {
+	if m == nil || i >= len(m.generators) {
+		return
+	}
+
+	m.generators[i].duration += time.Since(start)
+}
+
+// countedOutputRule counts the artifacts opened through the rule.
+type countedOutputRule struct {
+	genall.OutputRule
+
+	files *int
+}
+
+func (o countedOutputRule) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	w, err := o.OutputRule.Open(pkg, itemPath)
+	if err == nil {
+		*o.files++
+	}
+
+	return w, err //nolint:wrapcheck
+}
+
+func (o countedOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputRule, pkg, itemPath)
+}
+
+// writeMetrics writes the metrics of the run to the file at path in the Prometheus text exposition format, e.g. for the
+// textfile collector of the node exporter. The names of the metrics are part of the API, see the README.
+func writeMetrics(path string, m *runMetrics) error {
+	buf := new(bytes.Buffer)
+
+	writeMetricHeader(buf, "genutils_run_seconds", "Time spent running the command, from loading the packages to writing the files.") //nolint:lll
+	fmt.Fprintf(buf, "genutils_run_seconds %s\n", formatSeconds(time.Since(m.start)))
+
+	writeMetricHeader(buf, "genutils_packages", "Number of packages the generators ran on.")
+	fmt.Fprintf(buf, "genutils_packages %d\n", m.packages)
+
+	writeMetricHeader(buf, "genutils_generation_seconds", "Time spent running each generator.")
+
+	for _, gen := range m.generators {
+		fmt.Fprintf(buf, "genutils_generation_seconds{generator=%q} %s\n", gen.name, formatSeconds(gen.duration))
+	}
+
+	writeMetricHeader(buf, "genutils_generated_files", "Number of files written by each generator.")
+
+	for _, gen := range m.generators {
+		fmt.Fprintf(buf, "genutils_generated_files{generator=%q} %d\n", gen.name, gen.files)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil { //nolint:gosec,gomnd
+		return fmt.Errorf("writing the metrics: %w", err)
+	}
+
+	return nil
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a gauge.
+func writeMetricHeader(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// formatSeconds formats the duration as a number of seconds.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) //nolint:gomnd
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall/help"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// WithMarkerNamespace prefixes the names of the markers of the generators and output rules of the command with the
+// given namespace, e.g. "mycmd", so the commands embedded in a single tool with AddTo don't collide: the generator
+// "object" becomes "mycmd:object", and its output rules "mycmd:output:dir" and "mycmd:output:object:dir".
+// The generators, their aliases and categories are given to the builder without the namespace, but the options, the
+// default options, --only and --skip use the namespaced names, which are also the ones shown in the help. The markers
+// the generators read from the source code are not namespaced.
+func (b Builder) WithMarkerNamespace(namespace string) Builder {
+	return func() Cmd {
+		g := b()
+		g.markerNamespace = namespace
+
+		return g
+	}
+}
+
+// namespaceGenerators prefixes the names of the generators, of their aliases and of their categories with the marker
+// namespace of the command, if any. It panics if the namespace is not a valid marker name part.
+func namespaceGenerators(g Cmd) {
+	if g.markerNamespace == "" {
+		return
+	}
+
+	for _, genName := range SortedKeys(g.generators) {
+		generator := g.generators[genName]
+		delete(g.generators, genName)
+		g.generators[MarkerName(g.markerNamespace, genName)] = generator
+	}
+
+	for _, oldName := range SortedKeys(g.markerAliases) {
+		newName := g.markerAliases[oldName]
+		delete(g.markerAliases, oldName)
+		g.markerAliases[MarkerName(g.markerNamespace, oldName)] = MarkerName(g.markerNamespace, newName)
+	}
+
+	for _, genName := range SortedKeys(g.markerCategories) {
+		category := g.markerCategories[genName]
+		delete(g.markerCategories, genName)
+		g.markerCategories[MarkerName(g.markerNamespace, genName)] = category
+	}
+}
+
+// outputMarkerName returns the name of the option marker selecting the output rule of a generator, like
+// OutputMarkerName, prefixed with the marker namespace of the command, if any, e.g. "mycmd:output:object:dir" for
+// the generator "mycmd:object".
+func outputMarkerName(c Cmd, genName, ruleName string) string {
+	if c.markerNamespace == "" {
+		return OutputMarkerName(genName, ruleName)
+	}
+
+	genName = strings.TrimPrefix(genName, c.markerNamespace+markerNameSeparator)
+
+	return MarkerName(c.markerNamespace, OutputMarkerName(genName, ruleName))
+}
+
+// namespacedSortGroup sorts and groups the markers in the help like its SortGroup would without the marker namespace,
+// e.g. for help.SortByOption to tell the generators apart from the output rules by the number of parts of their names.
+type namespacedSortGroup struct {
+	help.SortGroup
+
+	namespace string
+}
+
+func (s namespacedSortGroup) Less(i, j *markers.Definition) bool {
+	return s.SortGroup.Less(s.trim(i), s.trim(j))
+}
+
+func (s namespacedSortGroup) Group(def *markers.Definition, h *markers.DefinitionHelp) string {
+	return s.SortGroup.Group(s.trim(def), h)
+}
+
+// trim returns a copy of the definition without the namespace in its name.
+func (s namespacedSortGroup) trim(def *markers.Definition) *markers.Definition {
+	trimmed := *def
+	trimmed.Name = strings.TrimPrefix(def.Name, s.namespace+markerNameSeparator)
+
+	return &trimmed
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing/fstest"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// WriteFS is a filesystem the artifacts can be written to, the write counterpart of fs.FS. Like for fs.FS, paths are
+// slash-separated and unrooted, e.g. "api/v1/zz_generated.foo.go".
+//
+// An afero.Fs can be used through a small adapter, returning its files from Create.
+type WriteFS interface {
+	// MkdirAll creates the directory and any missing parent.
+	MkdirAll(name string, perm fs.FileMode) error
+	// Create creates or truncates the file.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// OutputToFS outputs each artifact to the given filesystem, under base. The artifacts of a package are written to the
+// path of the package relative to the root of its Go module, so the filesystem mirrors the tree the generators would
+// write next to the packages. The artifacts not associated to a package are written to base directly.
+//
+// Generally useful to redirect the whole generation to a MemFS, to inspect or snapshot it before committing it.
+func OutputToFS(fsys WriteFS, base string) genall.OutputRule {
+	return outputToFS{fsys: fsys, base: base}
+}
+
+// outputToFS outputs each artifact to a WriteFS. Its fields are unexported so it has no marker arguments, and the
+// registered instance is used as is (see newRuntime).
+type outputToFS struct {
+	fsys WriteFS
+	base string
+}
+
+func (outputToFS) Help() *markers.DefinitionHelp {
+	return markers.SimpleHelp("", "outputs each artifact to a virtual filesystem, mirroring the tree of the packages.")
+}
+
+func (o outputToFS) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	if o.fsys == nil {
+		return nil, errors.New("OutputToFS requires a filesystem")
+	}
+
+	name := path.Join(o.base, filepath.ToSlash(itemPath))
+
+	if pkg != nil {
+		if len(pkg.CompiledGoFiles) == 0 {
+			return nil, errors.New("cannot output to a package with no path on disk")
+		}
+
+		root, err := ModuleRoot(pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, err := filepath.Rel(root, filepath.Dir(pkg.CompiledGoFiles[0]))
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		name = path.Join(o.base, filepath.ToSlash(dir), filepath.ToSlash(itemPath))
+	}
+
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("invalid output path %q", name)
+	}
+
+	if err := o.fsys.MkdirAll(path.Dir(name), fs.ModePerm); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return o.fsys.Create(name) //nolint:wrapcheck
+}
+
+// MemFS is an in-memory WriteFS. It is also a read-only fs.FS of the written files, which can be walked with
+// fs.WalkDir to inspect or snapshot the generation. It is safe for concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string][]byte), dirs: make(map[string]bool)}
+}
+
+func (m *MemFS) MkdirAll(name string, _ fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for ; name != "."; name = path.Dir(name) {
+		if _, isFile := m.files[name]; isFile {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+
+		m.dirs[name] = true
+	}
+
+	return nil
+}
+
+// Create creates or truncates the file. Its content is recorded when it is closed.
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dirs[name] {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	m.files[name] = nil
+
+	return &memFile{fs: m, name: name}, nil
+}
+
+// Open opens the file or directory for reading, implementing fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.snapshot().Open(name) //nolint:wrapcheck
+}
+
+// ReadFile returns the content of the file, implementing fs.ReadFileFS.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	return m.snapshot().ReadFile(name) //nolint:wrapcheck
+}
+
+// Files returns the paths of the written files, sorted.
+func (m *MemFS) Files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// snapshot returns a copy of the files and directories, so they can be read while others are written.
+func (m *MemFS) snapshot() fstest.MapFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(fstest.MapFS, len(m.files)+len(m.dirs))
+	for name := range m.dirs {
+		snapshot[name] = &fstest.MapFile{Mode: fs.ModeDir | fs.ModePerm}
+	}
+
+	for name, content := range m.files {
+		snapshot[name] = &fstest.MapFile{Data: bytes.Clone(content), Mode: fs.ModePerm}
+	}
+
+	return snapshot
+}
+
+// memFile buffers the content of a file of a MemFS until it is closed.
+type memFile struct {
+	bytes.Buffer
+
+	fs   *MemFS
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	f.fs.files[f.name] = bytes.Clone(f.Bytes())
+
+	return nil
+}
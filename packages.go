@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// LoadedPackagesMode is the minimal mode the packages given to LoaderPackages must be loaded with.
+const LoadedPackagesMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports |
+	packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedTypesSizes
+
+// LoaderPackages converts packages already loaded with go/packages, e.g. by a tool running its own static analysis,
+// into the packages the generators expect, without loading nor parsing them again.
+//
+// The packages must be loaded with at least LoadedPackagesMode, and their syntax must be parsed with comments (the
+// default of go/packages), so the markers can be collected. The conversion is limited: the Imports method of the
+// converted packages, and therefore the Checker of the GenerationContext, are not supported and panic, as only the
+// loader of controller-tools can resolve them. Generators must use the Types and TypesInfo of the packages instead.
+func LoaderPackages(pkgs []*packages.Package) ([]*loader.Package, error) {
+	roots := make([]*loader.Package, 0, len(pkgs))
+	errs := make([]error, 0)
+
+	for _, pkg := range pkgs {
+		if missing := missingPackageFields(pkg); len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("package %q is not fully loaded: missing %s", pkg.PkgPath,
+				strings.Join(missing, ", ")))
+
+			continue
+		}
+
+		roots = append(roots, &loader.Package{Package: pkg}) //nolint:exhaustruct
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return roots, nil
+}
+
+// GeneratePackages runs the generators activated by the given options against packages already loaded with
+// go/packages, see LoaderPackages. The "paths" option is ignored.
+func (c Cmd) GeneratePackages(pkgs []*packages.Package, opts []string) error {
+	roots, err := LoaderPackages(pkgs)
+	if err != nil {
+		return &LoadError{Err: err}
+	}
+
+	c.roots = roots
+
+	return c.Generate(opts)
+}
+
+// missingPackageFields returns the fields of the package which must be populated to be converted by LoaderPackages.
+func missingPackageFields(pkg *packages.Package) []string {
+	missing := make([]string, 0)
+
+	for _, field := range []struct {
+		name    string
+		missing bool
+	}{
+		{"Name", pkg.Name == ""},
+		{"CompiledGoFiles", len(pkg.CompiledGoFiles) == 0},
+		{"Fset", pkg.Fset == nil},
+		{"Syntax", pkg.Syntax == nil},
+		{"Types", pkg.Types == nil},
+		{"TypesInfo", pkg.TypesInfo == nil},
+	} {
+		if field.missing {
+			missing = append(missing, field.name)
+		}
+	}
+
+	return missing
+}
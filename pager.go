@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	pagerEnv         = "PAGER"
+	genutilsPagerEnv = "GENUTILS_PAGER"
+)
+
+// withPager pipes what is written to out through the pager specified by the GENUTILS_PAGER (or PAGER) environment
+// variable. It falls back to writing directly to out when out is not a terminal or no pager is specified.
+// The returned function must be called once everything was written, and waits for the pager to exit.
+func withPager(out io.Writer) (io.Writer, func() error) {
+	noop := func() error { return nil }
+
+	f, ok := out.(*os.File)
+	if !ok || !isTerminal(f) {
+		return out, noop
+	}
+
+	pager := os.Getenv(genutilsPagerEnv)
+	if pager == "" {
+		pager = os.Getenv(pagerEnv)
+	}
+
+	args := strings.Fields(pager)
+	if len(args) == 0 {
+		return out, noop
+	}
+
+	cmd := exec.Command(args[0], args[1:]...) //nolint:gosec
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return out, noop
+	}
+
+	if err := cmd.Start(); err != nil {
+		return out, noop
+	}
+
+	return stdin, func() error {
+		return errors.Join(stdin.Close(), cmd.Wait())
+	}
+}
+
+// isTerminal returns true if the file is a character device, e.g. a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
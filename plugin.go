@@ -0,0 +1,469 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// Plugins are executables dropped into a plugin directory (see Builder.WithPluginDir). Each plugin is registered as a
+// generator named after its file name, without extension, and is invoked as a subprocess:
+//
+//   - `<plugin> describe` must write a PluginDescription as JSON to stdout.
+//   - `<plugin> generate` reads a PluginRequest as JSON from stdin, and must write a PluginResponse as JSON to stdout.
+//
+// Anything written to stderr is forwarded to the stderr of the command. A non-zero exit code fails the generator.
+
+const (
+	// PluginProtocolVersion is the version of the plugin protocol implemented by genutils.
+	PluginProtocolVersion = 1
+
+	pluginDescribeCommand = "describe"
+	pluginGenerateCommand = "generate"
+)
+
+type (
+	// PluginDescription is returned by the "describe" command of a plugin.
+	PluginDescription struct {
+		// ProtocolVersion must be equal to PluginProtocolVersion.
+		ProtocolVersion int `json:"protocolVersion"`
+		// Help documents the options marker of the plugin.
+		Help *PluginHelp `json:"help,omitempty"`
+		// Markers are the markers the plugin reads from the source code.
+		Markers []PluginMarkerDescription `json:"markers,omitempty"`
+	}
+
+	// PluginHelp documents a marker.
+	PluginHelp struct {
+		Category string `json:"category,omitempty"`
+		Summary  string `json:"summary,omitempty"`
+		Details  string `json:"details,omitempty"`
+	}
+
+	// PluginMarkerDescription describes a marker read by a plugin. Its argument is free-form: "+name" gives a nil
+	// value, "+name=value" or "+name={key: value}" gives the parsed value.
+	PluginMarkerDescription struct {
+		Name string `json:"name"`
+		// Target is one of "package", "type" or "field".
+		Target string      `json:"target"`
+		Help   *PluginHelp `json:"help,omitempty"`
+	}
+
+	// PluginRequest is sent to the "generate" command of a plugin.
+	PluginRequest struct {
+		// Options are the options of the plugin, from "<plugin>:options={key: value}".
+		Options map[string]interface{} `json:"options,omitempty"`
+		Roots   []PluginRoot           `json:"roots"`
+	}
+
+	// PluginRoot is a package to generate for.
+	PluginRoot struct {
+		// ID is the import path of the package.
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		// Dir is the absolute path of the directory of the package.
+		Dir     string              `json:"dir"`
+		GoFiles []string            `json:"goFiles,omitempty"`
+		Markers []PluginMarkerValue `json:"markers,omitempty"`
+	}
+
+	// PluginMarkerValue is a marker of the plugin found in a package.
+	PluginMarkerValue struct {
+		Name   string `json:"name"`
+		Target string `json:"target"`
+		// Type is the name of the type the marker is set on, for "type" and "field" markers.
+		Type string `json:"type,omitempty"`
+		// Field is the name of the field the marker is set on, for "field" markers.
+		Field string      `json:"field,omitempty"`
+		Value interface{} `json:"value,omitempty"`
+	}
+
+	// PluginResponse is returned by the "generate" command of a plugin.
+	PluginResponse struct {
+		Files  []PluginFile  `json:"files,omitempty"`
+		Errors []PluginError `json:"errors,omitempty"`
+	}
+
+	// PluginFile is written with the output rule of the plugin, and formatted if it is a Go file.
+	PluginFile struct {
+		// Root is the ID of the package the file is written for.
+		Root string `json:"root"`
+		// Path is relative to the output of the package, e.g. "zz_generated.plugin.go".
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+
+	// PluginError is reported on the package with the given ID.
+	PluginError struct {
+		Root    string `json:"root"`
+		Message string `json:"message"`
+	}
+)
+
+// WithPluginDir registers each executable found in the directory as a generator, using the plugin protocol.
+// The plugins are discovered when the markers are registered, i.e. when the command runs.
+func (b Builder) WithPluginDir(path string) Builder {
+	return func() Cmd {
+		g := b()
+		g.pluginDirs = append(g.pluginDirs, path)
+
+		return g
+	}
+}
+
+// plugin is an executable implementing the plugin protocol.
+type plugin struct {
+	name        string
+	path        string
+	description PluginDescription
+}
+
+// pluginGenerator runs a plugin as a generator.
+type pluginGenerator struct {
+	// Options are passed as is to the plugin.
+	Options map[string]interface{} `marker:",optional"`
+
+	// plugin is lost when the options marker is parsed, and bound again with bindPlugin.
+	plugin *plugin
+	// dir is the directory the plugin runs in, the root directory of the run if any, bound with bindPlugin.
+	dir string
+}
+
+// pluginMarker holds the free-form argument of a marker of a plugin.
+type pluginMarker struct {
+	Value interface{} `marker:",optional"`
+}
+
+func (g pluginGenerator) Help() *markers.DefinitionHelp {
+	if g.plugin == nil || g.plugin.description.Help == nil {
+		return nil
+	}
+
+	return pluginDefinitionHelp(g.plugin.description.Help)
+}
+
+func (g pluginGenerator) RegisterMarkers(into *markers.Registry) error {
+	if g.plugin == nil {
+		return nil
+	}
+
+	for _, m := range g.plugin.description.Markers {
+		target, err := pluginMarkerTarget(m.Target)
+		if err != nil {
+			return fmt.Errorf("plugin %q: marker %q: %w", g.plugin.name, m.Name, err)
+		}
+
+		def, err := markers.MakeAnyTypeDefinition(m.Name, target, pluginMarker{})
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if err := into.Register(def); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if m.Help != nil {
+			into.AddHelp(def, pluginDefinitionHelp(m.Help))
+		}
+	}
+
+	return nil
+}
+
+func (g pluginGenerator) Generate(ctx *genall.GenerationContext) error {
+	if g.plugin == nil {
+		return fmt.Errorf("plugin generator is not bound to a plugin")
+	}
+
+	request := PluginRequest{Options: g.Options, Roots: make([]PluginRoot, 0, len(ctx.Roots))}
+	rootsByID := make(map[string]*loader.Package, len(ctx.Roots))
+
+	for _, root := range ctx.Roots {
+		pluginRoot, err := g.pluginRoot(ctx, root)
+		if err != nil {
+			root.AddError(err)
+
+			continue
+		}
+
+		request.Roots = append(request.Roots, pluginRoot)
+		rootsByID[root.ID] = root
+	}
+
+	response := PluginResponse{}
+	if err := g.plugin.call(g.dir, pluginGenerateCommand, request, &response); err != nil {
+		return err
+	}
+
+	for _, pluginErr := range response.Errors {
+		root, ok := rootsByID[pluginErr.Root]
+		if !ok {
+			return fmt.Errorf("plugin %q: %s", g.plugin.name, pluginErr.Message)
+		}
+
+		root.AddError(fmt.Errorf("plugin %q: %s", g.plugin.name, pluginErr.Message))
+	}
+
+	for _, file := range response.Files {
+		root, ok := rootsByID[file.Root]
+		if !ok {
+			return fmt.Errorf("plugin %q: file %q refers to unknown root %q", g.plugin.name, file.Path, file.Root)
+		}
+
+		if err := WriteFile(WriteFileOption{
+			Filename: file.Path,
+			Buffer:   bytes.NewBufferString(file.Content),
+			Ctx:      ctx,
+			Root:     root,
+		}); err != nil {
+			root.AddError(err)
+		}
+	}
+
+	return nil
+}
+
+// pluginRoot converts the package into a PluginRoot, with the markers of the plugin found in it.
+func (g pluginGenerator) pluginRoot(ctx *genall.GenerationContext, root *loader.Package) (PluginRoot, error) {
+	names := make(map[string]bool, len(g.plugin.description.Markers))
+	for _, m := range g.plugin.description.Markers {
+		names[m.Name] = true
+	}
+
+	pluginRoot := PluginRoot{ID: root.ID, Name: root.Name, GoFiles: root.GoFiles, Markers: make([]PluginMarkerValue, 0)}
+	if len(root.GoFiles) > 0 {
+		pluginRoot.Dir = filepath.Dir(root.GoFiles[0])
+	}
+
+	appendMarkers := func(values markers.MarkerValues, target, typeName, fieldName string) {
+		for _, name := range SortedKeys(values) {
+			if !names[name] {
+				continue
+			}
+
+			for _, value := range values[name] {
+				m, ok := value.(pluginMarker)
+				if !ok {
+					continue
+				}
+
+				pluginRoot.Markers = append(pluginRoot.Markers, PluginMarkerValue{
+					Name:   name,
+					Target: target,
+					Type:   typeName,
+					Field:  fieldName,
+					Value:  m.Value,
+				})
+			}
+		}
+	}
+
+	pkgMarkers, err := markers.PackageMarkers(ctx.Collector, root)
+	if err != nil {
+		return PluginRoot{}, err //nolint:wrapcheck
+	}
+
+	appendMarkers(pkgMarkers, "package", "", "")
+
+	if err := markers.EachType(ctx.Collector, root, func(info *markers.TypeInfo) {
+		appendMarkers(info.Markers, "type", info.Name, "")
+
+		for _, field := range info.Fields {
+			appendMarkers(field.Markers, "field", info.Name, field.Name)
+		}
+	}); err != nil {
+		return PluginRoot{}, err //nolint:wrapcheck
+	}
+
+	return pluginRoot, nil
+}
+
+// call runs the command of the plugin in dir, or the working directory if empty, sending the request as JSON to its
+// stdin and decoding its stdout as JSON into the response.
+func (p *plugin) call(dir, command string, request, response interface{}) error {
+	cmd := exec.Command(p.path, command) //nolint:gosec
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+
+	if request != nil {
+		input, err := json.Marshal(request)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("plugin %q: %s: %w", p.name, command, err)
+	}
+
+	if err := json.Unmarshal(output, response); err != nil {
+		return fmt.Errorf("plugin %q: %s: invalid response: %w", p.name, command, err)
+	}
+
+	return nil
+}
+
+// discoverPlugins describes each executable found in the directory.
+func discoverPlugins(dir string) ([]*plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	plugins := make([]*plugin, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		p := &plugin{
+			name: strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			path: filepath.Join(dir, entry.Name()),
+		}
+
+		if err := p.call("", pluginDescribeCommand, nil, &p.description); err != nil {
+			return nil, err
+		}
+
+		if p.description.ProtocolVersion != PluginProtocolVersion {
+			return nil, fmt.Errorf("plugin %q: unsupported protocol version %d, expected %d",
+				p.name, p.description.ProtocolVersion, PluginProtocolVersion)
+		}
+
+		plugins = append(plugins, p)
+	}
+
+	return plugins, nil
+}
+
+// registerPlugins adds the plugins found in the plugin directories to the generators of the command.
+func registerPlugins(g Cmd) error {
+	for _, dir := range g.pluginDirs {
+		plugins, err := discoverPlugins(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range plugins {
+			if _, exists := g.generators[p.name]; exists {
+				return fmt.Errorf("plugin %q conflicts with an existing generator", p.path)
+			}
+
+			g.generators[p.name] = pluginGenerator{plugin: p}
+		}
+	}
+
+	return nil
+}
+
+// bindPlugin binds the generator parsed from its options marker to its plugin, if it is a plugin generator.
+func bindPlugin(c Cmd, genName string, gen genall.Generator) (genall.Generator, error) {
+	parsedGen, isPlugin := gen.(pluginGenerator)
+	if !isPlugin {
+		return gen, nil
+	}
+
+	registered, ok := registeredPlugin(c, genName)
+	if !ok {
+		return nil, fmt.Errorf("generator %q is not a plugin", genName)
+	}
+
+	parsedGen.plugin = registered.plugin
+	parsedGen.dir = c.rootDir
+
+	return parsedGen, nil
+}
+
+// registerBoundMarkers registers the markers of the plugin and simple generators activated by the options, which are
+// not registered by genall.RegistryFromOptions since the parsed generators are not bound to their plugin or func.
+func registerBoundMarkers(c Cmd, into *markers.Registry, rawOpts []string) error {
+	for _, rawOpt := range rawOpts {
+		def := c.markerRegistry.Lookup("+"+strings.TrimPrefix(rawOpt, "+"), markers.DescribesPackage)
+		if def == nil {
+			continue
+		}
+
+		if registered, ok := registeredPlugin(c, def.Name); ok {
+			if err := registered.RegisterMarkers(into); err != nil {
+				return err
+			}
+		}
+
+		if registered, ok := c.generators[canonicalGeneratorName(c, def.Name)].(simpleGenerator); ok {
+			if err := registered.RegisterMarkers(into); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// registeredPlugin returns the plugin generator registered with the given name, resolving deprecated aliases.
+func registeredPlugin(c Cmd, genName string) (pluginGenerator, bool) {
+	registered, ok := c.generators[canonicalGeneratorName(c, genName)].(pluginGenerator)
+
+	return registered, ok
+}
+
+// pluginMarkerTarget parses the target of a marker of a plugin.
+func pluginMarkerTarget(target string) (markers.TargetType, error) {
+	switch target {
+	case "package":
+		return markers.DescribesPackage, nil
+	case "type":
+		return markers.DescribesType, nil
+	case "field":
+		return markers.DescribesField, nil
+	default:
+		return 0, fmt.Errorf("unknown target %q", target)
+	}
+}
+
+// pluginDefinitionHelp converts the help of a plugin into the help of a marker definition.
+func pluginDefinitionHelp(h *PluginHelp) *markers.DefinitionHelp {
+	return &markers.DefinitionHelp{
+		Category: h.Category,
+		DetailedHelp: markers.DetailedHelp{
+			Summary: h.Summary,
+			Details: h.Details,
+		},
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// progress reports how many generators completed out of the total. On a terminal, the report is updated in place.
+type progress struct {
+	w        io.Writer
+	tty      bool
+	total    int
+	packages int
+}
+
+// newProgress returns a progress reporting to w, or nil if the progress is disabled.
+func newProgress(enabled bool, w io.Writer, total, packages int) *progress {
+	if !enabled {
+		return nil
+	}
+
+	f, isFile := w.(*os.File)
+
+	return &progress{w: w, tty: isFile && isTerminal(f), total: total, packages: packages}
+}
+
+// update reports that the generator with the given name starts, after done generators completed.
+func (p *progress) update(done int, genName string) {
+	if p == nil {
+		return
+	}
+
+	line := fmt.Sprintf("[%d/%d] running %s on %d packages", done, p.total, genName, p.packages)
+
+	if p.tty {
+		// erase the previous report
+		_, _ = fmt.Fprintf(p.w, "\r\033[K%s", line)
+
+		return
+	}
+
+	_, _ = fmt.Fprintln(p.w, line)
+}
+
+// clear erases the report on a terminal, before something else is written.
+func (p *progress) clear() {
+	if p == nil || !p.tty {
+		return
+	}
+
+	_, _ = fmt.Fprint(p.w, "\r\033[K")
+}
+
+// done reports that all generators completed.
+func (p *progress) done() {
+	if p == nil {
+		return
+	}
+
+	line := fmt.Sprintf("[%d/%d] done", p.total, p.total)
+
+	if p.tty {
+		_, _ = fmt.Fprintf(p.w, "\r\033[K%s\n", line)
+
+		return
+	}
+
+	_, _ = fmt.Fprintln(p.w, line)
+}
+
+// run runs the generators of the runtime like genall.Runtime.Run does, one by one so the progress is reported as each
+// generator completes, until ctx is done. The errors added to the roots are recorded in origins, and the time spent by
+// each generator in m. It returns true if any generator failed.
+func run(
+	ctx context.Context, runtime *genall.Runtime, genNames []string, p *progress, origins errorOrigins, m *runMetrics,
+) bool {
+	if runtime.ErrorWriter == nil {
+		runtime.ErrorWriter = os.Stderr
+	}
+
+	hadErrs := false
+
+	// errors of the roots added when loading them
+	origins.record(runtime.Roots, "")
+
+	for i, gen := range runtime.Generators {
+		if ctx.Err() != nil {
+			p.clear()
+
+			return true
+		}
+
+		p.update(i, genNames[i])
+
+		genCtx := runtime.GenerationContext // make a shallow copy
+		genCtx.OutputRule = runtime.OutputRules.ForGenerator(gen)
+
+		// don't pass a typechecker to generators that don't provide a filter to avoid accidents
+		if _, needsChecking := (*gen).(genall.NeedsTypeChecking); !needsChecking {
+			genCtx.Checker = nil
+		}
+
+		start := time.Now()
+		err := generateWithContext(ctx, *gen, &genCtx)
+		m.timed(i, start)
+		origins.record(runtime.Roots, genNames[i])
+
+		if err != nil {
+			p.clear()
+
+			// the run is aborted, the caller reports why
+			if ctx.Err() != nil {
+				return true
+			}
+
+			_, _ = fmt.Fprintln(runtime.ErrorWriter, err)
+			hadErrs = true
+		}
+	}
+
+	p.done()
+
+	// skip TypeErrors, they're probably just from partial type checking
+	return loader.PrintErrors(runtime.Roots, packages.TypeError) || hadErrs
+}
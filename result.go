@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"io"
+	"slices"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// Result describes the files written by a run, see GenerateWithResult.
+type Result struct {
+	// FilesWritten is the number of files the generators wrote through their output rules, including those of the
+	// output rules not writing to the filesystem (e.g. "stdout").
+	FilesWritten int
+	// Paths are the sorted paths of the files written to the filesystem.
+	Paths []string
+}
+
+// GenerateWithResult runs the generators activated by the given options like Generate, and returns the files written
+// by the run, e.g. for build tools to skip their next steps when a run wrote nothing. Nothing is written, hence no
+// file is returned, with --verify or --diff, or if every package is up to date with --incremental.
+//
+// The result is returned along with the error, if any, as the generators which ran successfully may have written
+// files anyway.
+func (c Cmd) GenerateWithResult(opts []string) (*Result, error) {
+	c.result = &Result{Paths: make([]string, 0)}
+
+	err := c.Generate(opts)
+
+	slices.Sort(c.result.Paths)
+
+	return c.result, err
+}
+
+// recordWrites wraps the output rule of each generator of the runtime, so the files each generator writes are
+// recorded in the result, including those written when finalizing it.
+func (r *Result) recordWrites(runtime *genall.Runtime) {
+	for _, gen := range runtime.Generators {
+		runtime.OutputRules.ByGenerator[gen] = recordedOutputRule{
+			OutputRule: runtime.OutputRules.ForGenerator(gen),
+			result:     r,
+		}
+	}
+}
+
+// recordedOutputRule records the artifacts opened through the rule in the result.
+type recordedOutputRule struct {
+	genall.OutputRule
+
+	result *Result
+}
+
+func (o recordedOutputRule) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	w, err := o.OutputRule.Open(pkg, itemPath)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	o.result.FilesWritten++
+
+	if path, err := OutputPath(o.OutputRule, pkg, itemPath); err == nil && path != "" {
+		o.result.Paths = append(o.result.Paths, path)
+	}
+
+	return w, nil
+}
+
+func (o recordedOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputRule, pkg, itemPath)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+)
+
+// resolveRootDir returns the absolute path of the directory the packages are loaded and the relative paths of the run
+// resolved from, or an empty string for the working directory if dir is empty. The working directory of the process
+// is never changed, so runs with different root directories may be concurrent.
+func resolveRootDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", &UsageError{Err: fmt.Errorf("invalid root directory %q: not a directory", dir)}
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	return abs, nil
+}
+
+// rootedPath returns the path resolved from the root directory if it is relative, or as is.
+func rootedPath(rootDir, path string) string {
+	if rootDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(rootDir, path)
+}
+
+// rootOutputRule resolves the relative directories of the built-in output rules from the root directory, e.g. for
+// "output:dir=config". Other output rules resolve the relative paths they write to from the working directory.
+func rootOutputRule(rule genall.OutputRule, rootDir string) genall.OutputRule {
+	if rootDir == "" {
+		return rule
+	}
+
+	switch rule := rule.(type) {
+	case genall.OutputToDirectory:
+		return genall.OutputToDirectory(rootedPath(rootDir, string(rule)))
+	case genall.OutputArtifacts:
+		rule.Config = genall.OutputToDirectory(rootedPath(rootDir, string(rule.Config)))
+
+		// an empty Code directory outputs the code to the directory of each package
+		if rule.Code != "" {
+			rule.Code = genall.OutputToDirectory(rootedPath(rootDir, string(rule.Code)))
+		}
+
+		return rule
+	default:
+		return rule
+	}
+}
+
+// rootedInputRule reads the relative paths, e.g. of the header files read with GenerationContext.ReadFile, from the
+// root directory.
+type rootedInputRule struct {
+	genall.InputRule
+
+	rootDir string
+}
+
+func (r rootedInputRule) OpenForRead(path string) (io.ReadCloser, error) {
+	return r.InputRule.OpenForRead(rootedPath(r.rootDir, path)) //nolint:wrapcheck
+}
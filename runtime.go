@@ -0,0 +1,333 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// collectorCache holds the packages loaded by a previous run, with the markers collected and the types checked for
+// them, so they can be reused by the next runs with the same paths and generators.
+type collectorCache struct {
+	mu sync.Mutex
+
+	// key identifies the paths, generators, build flags and root directory the cache was filled for.
+	key       string
+	roots     []*loader.Package
+	collector *markers.Collector
+	checker   *loader.TypeChecker
+	// resetErrors restore the errors of each root to the ones reported when loading it, discarding the errors
+	// recorded by the previous runs.
+	resetErrors []func()
+}
+
+// WithCollectorCache reuses the packages loaded by a run, along with the markers collected and the types checked for
+// them, in the next runs with the same paths and generators. This avoids parsing the packages again when Generate is
+// invoked many times by a long-lived process, e.g. an editor integration or a watch loop.
+//
+// The cache is not aware of changes to the source files: it must be invalidated with Cmd.ResetCache whenever they
+// change.
+func (b Builder) WithCollectorCache() Builder {
+	return func() Cmd {
+		g := b()
+		g.cache = &collectorCache{}
+
+		return g
+	}
+}
+
+// WithBuildFlags passes the given flags to the build system when loading the packages, e.g. "-tags=integration", so the
+// generators see the files behind build constraints. The target platform is set with the GOOS and GOARCH environment
+// variables, as for the go command. The --build-flags flag adds flags for a single run.
+func (b Builder) WithBuildFlags(flags ...string) Builder {
+	return func() Cmd {
+		g := b()
+		g.buildFlags = append(g.buildFlags, flags...)
+
+		return g
+	}
+}
+
+// loaderConfig returns the configuration of the loader of the packages, with the given build flags.
+func loaderConfig(buildFlags []string) *packages.Config {
+	return &packages.Config{BuildFlags: buildFlags} //nolint:exhaustruct
+}
+
+// ResetCache invalidates the cache enabled with WithCollectorCache, so the next run loads the packages again.
+func (c Cmd) ResetCache() {
+	if c.cache == nil {
+		return
+	}
+
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+
+	c.cache.key = ""
+	c.cache.roots = nil
+	c.cache.collector = nil
+	c.cache.checker = nil
+	c.cache.resetErrors = nil
+}
+
+// newRuntime builds the runtime of the parsed options, like genall.FromOptions does, binding the plugin and simple
+// generators to their plugin or func. Errors loading the packages are returned as a *LoadError.
+func newRuntime(c Cmd, parsed []parsedOption) (*genall.Runtime, error) { //nolint:cyclop
+	for _, pattern := range c.exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, &UsageError{Err: fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)}
+		}
+	}
+
+	generators := make(genall.Generators, 0)
+	genNames := make([]string, 0)
+	generatorsByName := make(map[string]*genall.Generator)
+	outputRulesByGenerator := make(map[string]genall.OutputRule)
+	paths := make([]string, 0)
+
+	var defaultOutputRule genall.OutputRule
+
+	for _, opt := range parsed {
+		switch val := opt.val.(type) {
+		case genall.Generator:
+			gen, err := bindPlugin(c, opt.def.Name, val)
+			if err != nil {
+				return nil, err
+			}
+
+			gen = bindSimpleGenerator(c, opt.def.Name, gen)
+
+			generators = append(generators, &gen)
+			genNames = append(genNames, opt.def.Name)
+			generatorsByName[opt.def.Name] = &gen
+		case genall.OutputRule:
+			genName, ruleName, _ := parseOutputMarkerName(opt.def.Name)
+
+			// parsing the marker of a rule without arguments only yields its zero value: use the registered rule, which
+			// may be configured, e.g. OutputToFS
+			if rule, isRegistered := c.outputRules[ruleName]; isRegistered && len(opt.def.Fields) == 0 {
+				val = rule
+			}
+
+			if genName != "" {
+				outputRulesByGenerator[genName] = val
+			} else {
+				defaultOutputRule = val
+			}
+		case genall.InputPaths:
+			paths = append(paths, val...)
+		}
+	}
+
+	// like genall.FromOptions, a default output rule applies to every generator, otherwise each generator outputs
+	// to its own directory under "config".
+	outputRules := genall.OutputRules{Default: defaultOutputRule, ByGenerator: make(map[*genall.Generator]genall.OutputRule)}
+	if defaultOutputRule == nil {
+		outputRules = genall.DirectoryPerGenerator("config", generatorsByName)
+	}
+
+	for genName, rule := range outputRulesByGenerator {
+		outputRules.ByGenerator[generatorsByName[genName]] = rule
+	}
+
+	outputRules.Default = withArtifactDirs(rootOutputRule(outputRules.Default, c.rootDir))
+	for gen, rule := range outputRules.ByGenerator {
+		outputRules.ByGenerator[gen] = withArtifactDirs(rootOutputRule(rule, c.rootDir))
+	}
+
+	var inputRule genall.InputRule = genall.InputFromFileSystem
+	if c.rootDir != "" {
+		inputRule = rootedInputRule{InputRule: inputRule, rootDir: c.rootDir}
+	}
+
+	runtime := &genall.Runtime{
+		Generators: generators,
+		GenerationContext: genall.GenerationContext{
+			InputRule: inputRule,
+		},
+		OutputRules: outputRules,
+	}
+
+	if err := loadRoots(c, runtime, paths, genNames); err != nil {
+		return nil, err
+	}
+
+	// the roots are filtered after loading, so the cached roots are reused whatever the exclusions
+	runtime.Roots = excludeRoots(runtime.Roots, c.exclude)
+
+	return runtime, nil
+}
+
+// excludeRoots returns the roots whose import path matches none of the patterns, see matchPackagePattern.
+func excludeRoots(roots []*loader.Package, patterns []string) []*loader.Package {
+	if len(patterns) == 0 {
+		return roots
+	}
+
+	kept := make([]*loader.Package, 0, len(roots))
+
+	for _, root := range roots {
+		excluded := false
+
+		for _, pattern := range patterns {
+			if matchPackagePattern(pattern, root.PkgPath) {
+				excluded = true
+
+				break
+			}
+		}
+
+		if !excluded {
+			kept = append(kept, root)
+		}
+	}
+
+	return kept
+}
+
+// matchPackagePattern reports whether the import path matches the glob pattern, with the syntax of path.Match: "*"
+// matches any sequence of characters except "/". Like for the go tool, a pattern ending with "/..." also matches all
+// the subpackages, e.g. "example.com/mod/internal/..." matches "example.com/mod/internal/fixtures/a". The pattern must
+// be valid.
+func matchPackagePattern(pattern, pkgPath string) bool {
+	prefix, recursive := strings.CutSuffix(pattern, "/...")
+	if !recursive {
+		matched, _ := path.Match(pattern, pkgPath)
+
+		return matched
+	}
+
+	// match the leading elements of the import path against the prefix, element by element
+	elems := strings.Split(pkgPath, "/")
+	if n := strings.Count(prefix, "/") + 1; len(elems) > n {
+		pkgPath = strings.Join(elems[:n], "/")
+	}
+
+	matched, _ := path.Match(prefix, pkgPath)
+
+	return matched
+}
+
+// loadRoots loads the packages at the given paths into the runtime, or reuses the ones of the cache, or the ones given
+// to GeneratePackages.
+func loadRoots(c Cmd, runtime *genall.Runtime, paths, genNames []string) error {
+	key := strings.Join(paths, "\x00") + "\x00\x00" + strings.Join(genNames, "\x00") + "\x00\x00" +
+		strings.Join(c.buildFlags, "\x00") + "\x00\x00" + c.rootDir
+
+	// the packages already loaded by the caller are not cached
+	if c.roots != nil {
+		runtime.Roots = c.roots
+		runtime.Collector = &markers.Collector{Registry: &markers.Registry{}}
+		runtime.Checker = &loader.TypeChecker{NodeFilters: runtime.Generators.CheckFilters()}
+
+		return runtime.Generators.RegisterMarkers(runtime.Collector.Registry) //nolint:wrapcheck
+	}
+
+	if c.cache != nil {
+		c.cache.mu.Lock()
+		defer c.cache.mu.Unlock()
+
+		if c.cache.roots != nil && c.cache.key == key {
+			for _, resetErrors := range c.cache.resetErrors {
+				resetErrors()
+			}
+
+			runtime.Roots = c.cache.roots
+			runtime.Collector = c.cache.collector
+			runtime.Checker = c.cache.checker
+
+			return nil
+		}
+	}
+
+	cfg := loaderConfig(c.buildFlags)
+	cfg.Dir = c.rootDir
+
+	roots, err := loader.LoadRootsWithConfig(cfg, paths...)
+	if err != nil {
+		return &LoadError{Err: err}
+	}
+
+	runtime.Roots = roots
+	runtime.Collector = &markers.Collector{Registry: &markers.Registry{}}
+	runtime.Checker = &loader.TypeChecker{NodeFilters: runtime.Generators.CheckFilters()}
+
+	if err := runtime.Generators.RegisterMarkers(runtime.Collector.Registry); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if c.cache == nil {
+		return nil
+	}
+
+	resetErrors := make([]func(), 0, len(roots))
+
+	for _, root := range roots {
+		root, loadErrors := root, root.Errors[:len(root.Errors):len(root.Errors)]
+		resetErrors = append(resetErrors, func() { root.Errors = loadErrors })
+	}
+
+	c.cache.key = key
+	c.cache.roots = roots
+	c.cache.collector = runtime.Collector
+	c.cache.checker = runtime.Checker
+	c.cache.resetErrors = resetErrors
+
+	return nil
+}
+
+// artifactDirsOutputRule creates the missing directories of the artifacts written to the directory of a package, like
+// the other output rules, e.g. for WriteFileOption.Subdir: genall.OutputArtifacts does not.
+type artifactDirsOutputRule struct {
+	genall.OutputArtifacts
+}
+
+func (o artifactDirsOutputRule) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	path, err := OutputPath(o.OutputArtifacts, pkg, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return o.OutputArtifacts.Open(pkg, itemPath) //nolint:wrapcheck
+}
+
+func (o artifactDirsOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputArtifacts, pkg, itemPath)
+}
+
+// withArtifactDirs wraps the rule with artifactDirsOutputRule if it is a genall.OutputArtifacts.
+func withArtifactDirs(rule genall.OutputRule) genall.OutputRule {
+	if artifacts, ok := rule.(genall.OutputArtifacts); ok {
+		return artifactDirsOutputRule{OutputArtifacts: artifacts}
+	}
+
+	return rule
+}
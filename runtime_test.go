@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+func TestMatchPackagePattern(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pattern string
+		pkgPath string
+		want    bool
+	}{
+		{name: "exact path", pattern: "example.com/mod/a", pkgPath: "example.com/mod/a", want: true},
+		{name: "other path", pattern: "example.com/mod/a", pkgPath: "example.com/mod/b", want: false},
+		{name: "exact path is not recursive", pattern: "example.com/mod/a", pkgPath: "example.com/mod/a/b", want: false},
+		{name: "star within an element", pattern: "example.com/mod/*test", pkgPath: "example.com/mod/e2etest", want: true},
+		{name: "star across elements", pattern: "example.com/*", pkgPath: "example.com/mod/a", want: false},
+		{name: "recursive prefix itself", pattern: "example.com/mod/internal/...", pkgPath: "example.com/mod/internal",
+			want: true},
+		{name: "recursive subpackage", pattern: "example.com/mod/internal/...", pkgPath: "example.com/mod/internal/a",
+			want: true},
+		{name: "recursive nested subpackage", pattern: "example.com/mod/internal/...",
+			pkgPath: "example.com/mod/internal/fixtures/a", want: true},
+		{name: "recursive sibling with the prefix", pattern: "example.com/mod/internal/...",
+			pkgPath: "example.com/mod/internalfoo", want: false},
+		{name: "recursive parent", pattern: "example.com/mod/internal/...", pkgPath: "example.com/mod", want: false},
+		{name: "recursive star", pattern: "example.com/mod/*/testdata/...", pkgPath: "example.com/mod/a/testdata/b",
+			want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matchPackagePattern(tc.pattern, tc.pkgPath); got != tc.want {
+				t.Errorf("matchPackagePattern(%q, %q) = %v, want %v", tc.pattern, tc.pkgPath, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExcludeRoots(t *testing.T) {
+	roots := make([]*loader.Package, 0)
+	for _, pkgPath := range []string{"example.com/mod", "example.com/mod/api", "example.com/mod/api/fixtures"} {
+		roots = append(roots, &loader.Package{Package: &packages.Package{PkgPath: pkgPath}})
+	}
+
+	for _, tc := range []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{name: "no patterns", want: []string{"example.com/mod", "example.com/mod/api", "example.com/mod/api/fixtures"}},
+		{
+			name:     "excluded subpackage",
+			patterns: []string{"example.com/mod/api/fixtures"},
+			want:     []string{"example.com/mod", "example.com/mod/api"},
+		},
+		{
+			name:     "excluded subtree",
+			patterns: []string{"example.com/mod/api/..."},
+			want:     []string{"example.com/mod"},
+		},
+		{
+			name:     "several patterns",
+			patterns: []string{"example.com/mod", "example.com/mod/*/fixtures"},
+			want:     []string{"example.com/mod/api"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, root := range excludeRoots(roots, tc.patterns) {
+				got = append(got, root.PkgPath)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("excludeRoots(%q) = %q, want %q", tc.patterns, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// simpleGenerator registers a single marker and delegates the generation to a func, see NewSimpleGenerator and
+// NewAggregatingGenerator.
+type simpleGenerator struct {
+	// marker, fn and finalize are lost when the options marker is parsed, and bound again with bindSimpleGenerator.
+	marker *markers.Definition
+	fn     func(ctx *genall.GenerationContext) error
+	// finalize is invoked once all the generators ran, if not nil.
+	finalize func(ctx *genall.GenerationContext) error
+}
+
+// NewSimpleGenerator returns a generator registering the given marker, if not nil, and generating with fn. It is a
+// shortcut to implementing genall.Generator for one-off generators, which have no options:
+//
+//	marker := markers.Must(markers.MakeDefinition("hello:greet", markers.DescribesType, struct{}{}))
+//
+//	genutils.New("gencmd").
+//		WithGenerator("hello", genutils.NewSimpleGenerator(marker, func(ctx *genall.GenerationContext) error {
+//			// look up the types annotated with +hello:greet in ctx.Roots, and write the files with ctx.Open
+//			return nil
+//		})).
+//		Apply().
+//		Run()
+func NewSimpleGenerator(marker *markers.Definition, fn func(ctx *genall.GenerationContext) error) genall.Generator {
+	return simpleGenerator{marker: marker, fn: fn}
+}
+
+func (g simpleGenerator) RegisterMarkers(into *markers.Registry) error {
+	if g.marker == nil {
+		return nil
+	}
+
+	return into.Register(g.marker) //nolint:wrapcheck
+}
+
+func (g simpleGenerator) Generate(ctx *genall.GenerationContext) error {
+	if g.fn == nil {
+		return errors.New("simple generator has no func")
+	}
+
+	return g.fn(ctx)
+}
+
+func (g simpleGenerator) Finalize(ctx *genall.GenerationContext) error {
+	if g.finalize == nil {
+		return nil
+	}
+
+	return g.finalize(ctx)
+}
+
+// bindSimpleGenerator binds the generator parsed from its options marker to its marker and func, if it is a simple
+// generator.
+func bindSimpleGenerator(c Cmd, genName string, gen genall.Generator) genall.Generator {
+	if _, isSimple := gen.(simpleGenerator); !isSimple {
+		return gen
+	}
+
+	if registered, ok := c.generators[canonicalGeneratorName(c, genName)].(simpleGenerator); ok {
+		return registered
+	}
+
+	return gen
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+)
+
+// declChunk is the source of a top-level declaration, including the comments preceding it.
+type declChunk struct {
+	rank int
+	name string
+	src  []byte
+}
+
+// sortDeclarations re-emits the top-level declarations of the given Go source in a canonical order: constants,
+// variables, types, then functions, each sorted alphabetically. The package clause and imports are kept first, and
+// every comment moves along with the declaration following it.
+func sortDeclarations(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	// everything up to the end of the package clause or of the imports stays in place.
+	prefixEnd := endOfLine(src, offset(f.Name.End()))
+	decls := f.Decls
+
+	for len(decls) > 0 {
+		genDecl, ok := decls[0].(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			break
+		}
+
+		prefixEnd = endOfLine(src, offset(genDecl.End()))
+		decls = decls[1:]
+	}
+
+	if len(decls) == 0 {
+		return src, nil
+	}
+
+	chunks := make([]declChunk, 0, len(decls))
+	start := prefixEnd
+
+	for _, decl := range decls {
+		end := endOfLine(src, offset(decl.End()))
+		rank, name := declSortKey(decl)
+
+		chunks = append(chunks, declChunk{
+			rank: rank,
+			name: name,
+			src:  bytes.Trim(src[start:end], "\n"),
+		})
+
+		start = end
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if chunks[i].rank != chunks[j].rank {
+			return chunks[i].rank < chunks[j].rank
+		}
+
+		return chunks[i].name < chunks[j].name
+	})
+
+	out := bytes.NewBuffer(make([]byte, 0, len(src)))
+	out.Write(bytes.TrimRight(src[:prefixEnd], "\n"))
+
+	for _, chunk := range chunks {
+		out.WriteString("\n\n")
+		out.Write(chunk.src)
+	}
+
+	out.WriteString("\n")
+
+	if suffix := bytes.Trim(src[start:], "\n"); len(suffix) > 0 {
+		out.WriteString("\n")
+		out.Write(suffix)
+		out.WriteString("\n")
+	}
+
+	return out.Bytes(), nil
+}
+
+// declSortKey returns the rank of the kind of the declaration and the name it is sorted by.
+func declSortKey(decl ast.Decl) (int, string) {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		name := decl.Name.Name
+		if decl.Recv != nil && len(decl.Recv.List) > 0 {
+			name = receiverTypeName(decl.Recv.List[0].Type) + "." + name
+		}
+
+		return 3, name //nolint:gomnd
+	case *ast.GenDecl:
+		name := ""
+
+		if len(decl.Specs) > 0 {
+			switch spec := decl.Specs[0].(type) {
+			case *ast.TypeSpec:
+				name = spec.Name.Name
+			case *ast.ValueSpec:
+				if len(spec.Names) > 0 {
+					name = spec.Names[0].Name
+				}
+			}
+		}
+
+		switch decl.Tok { //nolint:exhaustive
+		case token.CONST:
+			return 0, name
+		case token.VAR:
+			return 1, name
+		default:
+			return 2, name //nolint:gomnd
+		}
+	default:
+		return 4, "" //nolint:gomnd
+	}
+}
+
+// receiverTypeName returns the name of the type of a method receiver, e.g. "T" for "*T" or "T[K]".
+func receiverTypeName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	default:
+		return ""
+	}
+}
+
+// endOfLine returns the offset right after the end of the line containing the given offset, so trailing line
+// comments stay with the declaration they follow.
+func endOfLine(src []byte, offset int) int {
+	if i := bytes.IndexByte(src[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+
+	return len(src)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// stdoutOutputRule outputs everything to the standard output it was created with, like genall.OutputToStdout, while
+// os.Stdout is redirected.
+type stdoutOutputRule struct {
+	w io.Writer
+}
+
+func (o stdoutOutputRule) Open(_ *loader.Package, _ string) (io.WriteCloser, error) {
+	return nopCloser{o.w}, nil
+}
+
+func (stdoutOutputRule) OutputPath(_ *loader.Package, _ string) (string, error) {
+	return "", nil
+}
+
+// generatorStdout returns where to redirect what the generators print to the standard output during the run: the
+// writer set with WithGeneratorStdout, or the standard error if an output rule of the runtime writes to the standard
+// output. It returns nil if the standard output is not redirected.
+func generatorStdout(c Cmd, runtime *genall.Runtime) io.Writer {
+	if c.generatorStdout != nil {
+		if c.generatorStdout == io.Writer(os.Stdout) {
+			return nil
+		}
+
+		return c.generatorStdout
+	}
+
+	if writesToStdout(runtime.OutputRules.Default) {
+		return os.Stderr
+	}
+
+	for _, rule := range runtime.OutputRules.ByGenerator {
+		if writesToStdout(rule) {
+			return os.Stderr
+		}
+	}
+
+	return nil
+}
+
+// redirectStdout redirects os.Stdout to w until the returned function is called, keeping the output rules of the
+// runtime writing to the standard output on the actual standard output. It does nothing if w is nil.
+func redirectStdout(runtime *genall.Runtime, w io.Writer) (func() error, error) {
+	if w == nil {
+		return func() error { return nil }, nil
+	}
+
+	stdout := os.Stdout
+
+	if runtime.OutputRules.Default == genall.OutputToStdout {
+		runtime.OutputRules.Default = stdoutOutputRule{w: stdout}
+	}
+
+	for gen, rule := range runtime.OutputRules.ByGenerator {
+		if rule == genall.OutputToStdout {
+			runtime.OutputRules.ByGenerator[gen] = stdoutOutputRule{w: stdout}
+		}
+	}
+
+	if f, isFile := w.(*os.File); isFile {
+		os.Stdout = f
+
+		return func() error {
+			os.Stdout = stdout
+
+			return nil
+		}, nil
+	}
+
+	// other writers, e.g. a buffer capturing the output, are fed through a pipe
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	copied := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(w, r)
+		copied <- errors.Join(err, r.Close())
+	}()
+
+	os.Stdout = pw
+
+	return func() error {
+		os.Stdout = stdout
+
+		return errors.Join(pw.Close(), <-copied)
+	}, nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// OutputToTar outputs all the artifacts of a run as a single tar archive, written to w once all the generators and
+// finalizers ran, e.g. to pipe the generation across a process or container boundary without a shared filesystem:
+//
+//	genutils.New("gencmd").WithOutputRule("tar", genutils.OutputToTar(os.Stdout))
+//
+// Like for OutputToFS, the artifacts of a package are archived under the path of the package relative to the root of
+// its Go module. No archive is written with --verify, --diff, nor by GenerateToMap.
+func OutputToTar(w io.Writer) genall.OutputRule {
+	return outputToTar{archive: &tarArchive{w: w, files: NewMemFS()}}
+}
+
+// outputToTar outputs each artifact to its tar archive. Its fields are unexported so it has no marker arguments, and
+// the registered instance is used as is (see newRuntime).
+type outputToTar struct {
+	archive *tarArchive
+}
+
+// tarArchive buffers the artifacts of a run until they are written to w as a tar archive.
+type tarArchive struct {
+	mu    sync.Mutex
+	w     io.Writer
+	files *MemFS
+}
+
+func (outputToTar) Help() *markers.DefinitionHelp {
+	return markers.SimpleHelp("", "outputs all the artifacts as a single tar archive, mirroring the tree of the packages.")
+}
+
+func (o outputToTar) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	if o.archive == nil {
+		return nil, errors.New("OutputToTar requires a writer")
+	}
+
+	o.archive.mu.Lock()
+	files := o.archive.files
+	o.archive.mu.Unlock()
+
+	return outputToFS{fsys: files}.Open(pkg, itemPath)
+}
+
+func (outputToTar) OutputPath(_ *loader.Package, _ string) (string, error) {
+	return "", nil
+}
+
+// flush writes the artifacts buffered since the last flush to the writer of the archive, sorted by path.
+func (a *tarArchive) flush() error {
+	a.mu.Lock()
+	files := a.files
+	a.files = NewMemFS()
+	a.mu.Unlock()
+
+	tw := tar.NewWriter(a.w)
+	modTime := time.Now()
+
+	for _, name := range files.Files() {
+		content, err := files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(content)),
+			Mode:     0o644, //nolint:gomnd
+			ModTime:  modTime,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if _, err := tw.Write(content); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	return tw.Close() //nolint:wrapcheck
+}
+
+// startTarArchives returns the archives of the output rules of the runtime, before they are wrapped, discarding the
+// artifacts of a previous run which did not complete.
+func startTarArchives(runtime *genall.Runtime) []*tarArchive {
+	archives := make([]*tarArchive, 0)
+	seen := make(map[*tarArchive]bool)
+
+	rules := []genall.OutputRule{runtime.OutputRules.Default}
+	for _, rule := range runtime.OutputRules.ByGenerator {
+		rules = append(rules, rule)
+	}
+
+	for _, rule := range rules {
+		if o, ok := rule.(outputToTar); ok && o.archive != nil && !seen[o.archive] {
+			seen[o.archive] = true
+			archives = append(archives, o.archive)
+
+			o.archive.mu.Lock()
+			o.archive.files = NewMemFS()
+			o.archive.mu.Unlock()
+		}
+	}
+
+	return archives
+}
+
+// flushTarArchives writes the archives of the run.
+func flushTarArchives(archives []*tarArchive) error {
+	errs := make([]error, 0)
+
+	for _, archive := range archives {
+		errs = append(errs, archive.flush())
+	}
+
+	return errors.Join(errs...)
+}
+
+// writesToStdout reports whether the output rule writes the artifacts to the standard output.
+func writesToStdout(rule genall.OutputRule) bool {
+	if o, ok := rule.(outputToTar); ok {
+		return o.archive != nil && o.archive.w == io.Writer(os.Stdout)
+	}
+
+	return rule == genall.OutputToStdout
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// loggedOutputRule logs the destination of each artifact opened through the rule, along with the option which
+// selected the rule.
+type loggedOutputRule struct {
+	genall.OutputRule
+
+	w        io.Writer
+	genName  string
+	ruleName string
+}
+
+func (o loggedOutputRule) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	destination := itemPath
+
+	if path, err := OutputPath(o.OutputRule, pkg, itemPath); err == nil && path != "" {
+		destination = displayPath(path)
+	} else if err == nil {
+		destination += " (not written to the filesystem)"
+	}
+
+	_, _ = fmt.Fprintf(o.w, "%s: %s -> %s\n", o.genName, o.ruleName, destination)
+
+	return o.OutputRule.Open(pkg, itemPath) //nolint:wrapcheck
+}
+
+func (o loggedOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputRule, pkg, itemPath)
+}
+
+// logOutputRules wraps the output rule of each generator of the runtime, so the destination of each artifact is
+// logged to w with the option which selected the rule, like newRuntime selects them.
+func logOutputRules(runtime *genall.Runtime, parsed []parsedOption, w io.Writer) {
+	defaultRuleName := "default"
+	ruleNames := make(map[string]string)
+
+	for _, opt := range parsed {
+		if _, isRule := opt.val.(genall.OutputRule); !isRule {
+			continue
+		}
+
+		if genName, _, _ := parseOutputMarkerName(opt.def.Name); genName != "" {
+			ruleNames[genName] = opt.def.Name
+		} else {
+			defaultRuleName = opt.def.Name
+		}
+	}
+
+	for i, genName := range generatorNames(parsed) {
+		gen := runtime.Generators[i]
+
+		ruleName, hasRule := ruleNames[genName]
+		if !hasRule {
+			ruleName = defaultRuleName
+		}
+
+		runtime.OutputRules.ByGenerator[gen] = loggedOutputRule{
+			OutputRule: runtime.OutputRules.ForGenerator(gen),
+			w:          w,
+			genName:    genName,
+			ruleName:   ruleName,
+		}
+	}
+}
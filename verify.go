@@ -0,0 +1,195 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// OutputPather is implemented by output rules writing artifacts to the filesystem, to report the path Open writes an
+// artifact to. Custom output rules must implement it to be usable with OutputToBuffer, e.g. with --verify.
+type OutputPather interface {
+	OutputPath(pkg *loader.Package, itemPath string) (string, error)
+}
+
+// OutputToBuffer records each artifact in memory instead of writing it. Artifacts are keyed by the path Rule would
+// have written them to. Artifacts of rules not writing to the filesystem (e.g. "stdout") are discarded.
+//
+// Generally useful to compare the output of the generators with the files on disk.
+type OutputToBuffer struct {
+	// Rule is the output rule the paths of the artifacts are resolved with.
+	Rule genall.OutputRule
+	// Files are the recorded artifacts by path. It must be initialized, and can be shared by several rules.
+	Files map[string]*bytes.Buffer
+}
+
+func (o OutputToBuffer) Open(pkg *loader.Package, itemPath string) (io.WriteCloser, error) {
+	if o.Files == nil {
+		return nil, errors.New("OutputToBuffer.Files must be initialized")
+	}
+
+	path, err := OutputPath(o.Rule, pkg, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return nopCloser{io.Discard}, nil
+	}
+
+	buffer := new(bytes.Buffer)
+	o.Files[path] = buffer
+
+	return nopCloser{buffer}, nil
+}
+
+// OutputPath returns the path the output rule writes the artifact to, or an empty string if the rule does not write
+// to the filesystem.
+func OutputPath(rule genall.OutputRule, pkg *loader.Package, itemPath string) (string, error) {
+	switch rule := rule.(type) {
+	case OutputPather:
+		return rule.OutputPath(pkg, itemPath) //nolint:wrapcheck
+	case OutputToBuffer:
+		return OutputPath(rule.Rule, pkg, itemPath)
+	case genall.OutputToDirectory:
+		return filepath.Join(string(rule), itemPath), nil
+	case genall.OutputArtifacts:
+		switch {
+		case pkg == nil:
+			return filepath.Join(string(rule.Config), itemPath), nil
+		case rule.Code != "":
+			return filepath.Join(string(rule.Code), itemPath), nil
+		case len(pkg.CompiledGoFiles) == 0:
+			return "", errors.New("cannot output to a package with no path on disk")
+		default:
+			return filepath.Join(filepath.Dir(pkg.CompiledGoFiles[0]), itemPath), nil
+		}
+	}
+
+	if rule == nil || rule == genall.OutputToNothing || rule == genall.OutputToStdout {
+		return "", nil
+	}
+
+	return "", fmt.Errorf("cannot resolve the path of the output of %T, it must implement OutputPather", rule)
+}
+
+// nopCloser is a WriteCloser whose Close does nothing.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// displayPath returns the path relative to the current working directory if it is inside it, using slashes.
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil && filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+
+	return filepath.ToSlash(path)
+}
+
+// bufferOutputs replaces the output rules of the runtime with OutputToBuffer rules sharing the returned files.
+func bufferOutputs(runtime *genall.Runtime) map[string]*bytes.Buffer {
+	files := make(map[string]*bytes.Buffer)
+
+	runtime.OutputRules.Default = OutputToBuffer{Rule: runtime.OutputRules.Default, Files: files}
+	for gen, rule := range runtime.OutputRules.ByGenerator {
+		runtime.OutputRules.ByGenerator[gen] = OutputToBuffer{Rule: rule, Files: files}
+	}
+
+	return files
+}
+
+// verifyOutputs compares the generated files with the files on disk, printing the list of out-of-date files and a
+// unified diff for each. It returns ErrOutOfDate if any file differs.
+func verifyOutputs(w io.Writer, files map[string]*bytes.Buffer) error {
+	outOfDate, diffs, err := diffOutputs(files)
+	if err != nil {
+		return err
+	}
+
+	if len(outOfDate) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(w, "out-of-date generated files:")
+	for _, path := range outOfDate {
+		_, _ = fmt.Fprintf(w, "\t%s\n", path)
+	}
+
+	_, _ = fmt.Fprintln(w)
+	_, _ = w.Write(diffs)
+
+	return ErrOutOfDate
+}
+
+// printOutputsDiff prints a unified diff between the files on disk and the generated files, for review.
+func printOutputsDiff(w io.Writer, files map[string]*bytes.Buffer) error {
+	_, diffs, err := diffOutputs(files)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(diffs)
+
+	return err //nolint:wrapcheck
+}
+
+// diffOutputs compares the generated files with the files on disk, returning the paths of the files which differ,
+// and a unified diff for each.
+func diffOutputs(files map[string]*bytes.Buffer) ([]string, []byte, error) {
+	outOfDate := make([]string, 0)
+	diffs := new(bytes.Buffer)
+
+	for _, path := range SortedKeys(files) {
+		onDisk, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err //nolint:wrapcheck
+		}
+
+		generated := files[path].Bytes()
+		if err == nil && bytes.Equal(onDisk, generated) {
+			continue
+		}
+
+		path = displayPath(path)
+		outOfDate = append(outOfDate, path)
+
+		from := "a/" + path
+		if err != nil {
+			from = "/dev/null"
+		}
+
+		writeUnifiedDiff(diffs, from, "b/"+path, onDisk, generated)
+	}
+
+	return outOfDate, diffs.Bytes(), nil
+}
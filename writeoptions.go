@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Alexandre Mahdhaoui
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genutils
+
+import (
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// writeOptionsOutputRule marks the output rule of the generators of a run with the options applying to all the files
+// written with WriteFile through the GenerationContext, e.g. with Builder.WithoutFormatting or --skip-empty.
+type writeOptionsOutputRule struct {
+	genall.OutputRule
+
+	// skipFormat disables the formatting of the files.
+	skipFormat bool
+	// skipEmpty skips writing the files whose content is empty.
+	skipEmpty bool
+}
+
+func (o writeOptionsOutputRule) OutputPath(pkg *loader.Package, itemPath string) (string, error) {
+	return OutputPath(o.OutputRule, pkg, itemPath)
+}
+
+// withWriteOptions wraps the output rule of each generator of the runtime with the options of the run for WriteFile.
+func withWriteOptions(runtime *genall.Runtime, skipFormat, skipEmpty bool) {
+	for _, gen := range runtime.Generators {
+		runtime.OutputRules.ByGenerator[gen] = writeOptionsOutputRule{
+			OutputRule: runtime.OutputRules.ForGenerator(gen),
+			skipFormat: skipFormat,
+			skipEmpty:  skipEmpty,
+		}
+	}
+}
+
+// runWriteOptions returns the options of the run for the files written with the generation context, if any.
+func runWriteOptions(ctx *genall.GenerationContext) writeOptionsOutputRule {
+	if ctx == nil {
+		return writeOptionsOutputRule{}
+	}
+
+	o, _ := ctx.OutputRule.(writeOptionsOutputRule)
+
+	return o
+}
+
+// runSkipsFormatting reports whether the files written with the generation context must not be formatted.
+func runSkipsFormatting(ctx *genall.GenerationContext) bool {
+	return runWriteOptions(ctx).skipFormat
+}
+
+// runSkipsEmpty reports whether the files written with the generation context must not be written if their content is
+// empty.
+func runSkipsEmpty(ctx *genall.GenerationContext) bool {
+	return runWriteOptions(ctx).skipEmpty
+}